@@ -0,0 +1,244 @@
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Extension is appended to the name of a directory archived by Write
+const Extension = ".tar.gz"
+
+// ErrUnsafePath is returned by Extract for archive entries that would be
+// written outside of the destination directory
+var ErrUnsafePath = errors.New("archive entry points outside of the destination directory")
+
+// gzipMagic are the first bytes of every gzip stream
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// IsArchive reports whether data is an archive written by Write: a gzip
+// compressed tar archive starting with the archived directory. Other gzip
+// compressed files are not archives.
+func IsArchive(data []byte) bool {
+	if !bytes.HasPrefix(data, gzipMagic) {
+		return false
+	}
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return false
+	}
+	header, err := tar.NewReader(gz).Next()
+	return err == nil && header.Typeflag == tar.TypeDir
+}
+
+// Write writes a gzip compressed tar archive of the directory dir to dst.
+// Entries are named relative to the parent of dir, so extracting the archive
+// recreates the directory itself. Only regular files and directories are
+// included, and owner information is left out.
+func Write(dst io.Writer, dir string) error {
+	gz := gzip.NewWriter(dst)
+	tw := tar.NewWriter(gz)
+
+	base := filepath.Dir(filepath.Clean(dir))
+	err := filepath.WalkDir(dir, func(file string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !entry.IsDir() && !entry.Type().IsRegular() {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(base, file)
+		if err != nil {
+			return err
+		}
+
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(rel)
+		if entry.IsDir() {
+			header.Name += "/"
+		}
+		header.Uid, header.Gid = 0, 0
+		header.Uname, header.Gname = "", ""
+
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			return nil
+		}
+
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to archive %s: %w", dir, err)
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// Extract unpacks a gzip compressed tar archive into the directory dir,
+// creating it if needed. The archive is unpacked into a temporary directory
+// inside dir first, and its top-level entries are only moved into dir once
+// all of src was read without error, so nothing is left behind if the
+// archive is broken or src fails, not even dir if it was created. Existing
+// files are never overwritten, and entries other than regular files and
+// directories are rejected.
+func Extract(src io.Reader, dir string) (err error) {
+	gz, err := gzip.NewReader(src)
+	if err != nil {
+		return fmt.Errorf("failed to read archive: %w", err)
+	}
+
+	if created := missingDir(dir); created != "" {
+		defer func() {
+			if err != nil {
+				os.RemoveAll(created)
+			}
+		}()
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	tmp, err := os.MkdirTemp(dir, ".extract-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+
+	if err := extractAll(gz, tmp); err != nil {
+		return err
+	}
+	// Whatever follows the tar archive is read as well, so that the gzip
+	// checksum and errors of src, such as a failed authentication of the last
+	// encrypted chunk, are seen
+	if _, err := io.Copy(io.Discard, gz); err != nil {
+		return fmt.Errorf("failed to read archive: %w", err)
+	}
+	if err := gz.Close(); err != nil {
+		return fmt.Errorf("failed to read archive: %w", err)
+	}
+	if _, err := io.Copy(io.Discard, src); err != nil {
+		return fmt.Errorf("failed to read archive: %w", err)
+	}
+
+	entries, err := os.ReadDir(tmp)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		target := filepath.Join(dir, entry.Name())
+		if _, err := os.Lstat(target); err == nil {
+			return fmt.Errorf("%s already exists", target)
+		}
+	}
+	for _, entry := range entries {
+		if err := os.Rename(filepath.Join(tmp, entry.Name()), filepath.Join(dir, entry.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// missingDir returns the outermost directory that creating dir creates,
+// which is dir itself or one of its parents, or "" if dir exists
+func missingDir(dir string) string {
+	missing := ""
+	for d := filepath.Clean(dir); ; d = filepath.Dir(d) {
+		if _, err := os.Lstat(d); !errors.Is(err, fs.ErrNotExist) {
+			return missing
+		}
+		missing = d
+		if filepath.Dir(d) == d {
+			return missing
+		}
+	}
+}
+
+// extractAll writes the entries of a tar archive into dir
+func extractAll(src io.Reader, dir string) error {
+	tr := tar.NewReader(src)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read archive: %w", err)
+		}
+
+		target, err := safeJoin(dir, header.Name)
+		if err != nil {
+			return err
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, header.FileInfo().Mode().Perm()|0700); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := extractFile(tr, target, header.FileInfo().Mode().Perm()); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("%s: unsupported archive entry type %q", header.Name, header.Typeflag)
+		}
+	}
+}
+
+// extractFile writes a single file from the archive, refusing to overwrite
+// an existing one
+func extractFile(src io.Reader, target string, perm fs.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, src); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// safeJoin returns the path of an archive entry inside dir, rejecting names
+// that are absolute or climb out of it
+func safeJoin(dir, name string) (string, error) {
+	if path.IsAbs(name) || strings.Contains(name, "\\") {
+		return "", fmt.Errorf("%s: %w", name, ErrUnsafePath)
+	}
+
+	target := filepath.Join(dir, filepath.FromSlash(name))
+	rel, err := filepath.Rel(dir, target)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s: %w", name, ErrUnsafePath)
+	}
+	return target, nil
+}
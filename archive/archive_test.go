@@ -0,0 +1,184 @@
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// entry is a tar entry written by newArchive
+type entry struct {
+	name     string
+	typeflag byte
+	body     string
+	linkname string
+}
+
+// newArchive returns a gzip compressed tar archive of the entries
+func newArchive(t *testing.T, entries ...entry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for _, e := range entries {
+		header := &tar.Header{Name: e.name, Typeflag: e.typeflag, Mode: 0600, Size: int64(len(e.body)), Linkname: e.linkname}
+		if e.typeflag != tar.TypeReg {
+			header.Size = 0
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(e.body[:header.Size])); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestSafeJoin(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "out")
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"docs/", filepath.Join(dir, "docs")},
+		{"docs/notes.txt", filepath.Join(dir, "docs", "notes.txt")},
+		{"./docs/../notes.txt", filepath.Join(dir, "notes.txt")},
+		{"../notes.txt", ""},
+		{"docs/../../notes.txt", ""},
+		{"..", ""},
+		{"/etc/passwd", ""},
+		{`..\notes.txt`, ""},
+		{`C:\notes.txt`, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := safeJoin(dir, test.name)
+			if test.want == "" {
+				if !errors.Is(err, ErrUnsafePath) {
+					t.Errorf("got %q and %v, want ErrUnsafePath", got, err)
+				}
+				return
+			}
+			if err != nil || got != test.want {
+				t.Errorf("got %q and %v, want %q", got, err, test.want)
+			}
+		})
+	}
+}
+
+func TestIsArchive(t *testing.T) {
+	var plain bytes.Buffer
+	gz := gzip.NewWriter(&plain)
+	gz.Write([]byte("not a tar archive"))
+	gz.Close()
+
+	tests := []struct {
+		name string
+		data []byte
+		want bool
+	}{
+		{"directory", newArchive(t, entry{name: "docs/", typeflag: tar.TypeDir}, entry{name: "docs/a.txt", typeflag: tar.TypeReg, body: "a"}), true},
+		{"file first", newArchive(t, entry{name: "a.txt", typeflag: tar.TypeReg, body: "a"}), false},
+		{"empty archive", newArchive(t), false},
+		{"gzip", plain.Bytes(), false},
+		{"truncated gzip", plain.Bytes()[:4], false},
+		{"text", []byte("hello"), false},
+		{"empty", nil, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := IsArchive(test.data); got != test.want {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestWriteAndExtract(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "docs")
+	if err := os.MkdirAll(filepath.Join(src, "sub"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(src, "sub", "a.txt"), []byte("a"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := Write(&buf, src); err != nil {
+		t.Fatal(err)
+	}
+	if !IsArchive(buf.Bytes()) {
+		t.Fatal("Write did not write an archive")
+	}
+
+	dir := filepath.Join(t.TempDir(), "out")
+	if err := Extract(bytes.NewReader(buf.Bytes()), dir); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "docs", "sub", "a.txt"))
+	if err != nil || string(data) != "a" {
+		t.Errorf("got %q and %v, want the archived file", data, err)
+	}
+
+	// Extracting again does not overwrite the directory
+	if err := Extract(bytes.NewReader(buf.Bytes()), dir); err == nil {
+		t.Error("extracting over an existing directory succeeded")
+	}
+}
+
+func TestExtractRejectsUnsafeEntries(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []entry
+		unsafe  bool
+	}{
+		{"traversal", []entry{{name: "docs/", typeflag: tar.TypeDir}, {name: "docs/../../a.txt", typeflag: tar.TypeReg, body: "a"}}, true},
+		{"absolute path", []entry{{name: "/tmp/a.txt", typeflag: tar.TypeReg, body: "a"}}, true},
+		{"symlink", []entry{{name: "docs/", typeflag: tar.TypeDir}, {name: "docs/passwd", typeflag: tar.TypeSymlink, linkname: "/etc/passwd"}}, false},
+		{"hard link", []entry{{name: "docs/", typeflag: tar.TypeDir}, {name: "docs/passwd", typeflag: tar.TypeLink, linkname: "/etc/passwd"}}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			parent := t.TempDir()
+			dir := filepath.Join(parent, "out", "nested")
+			err := Extract(bytes.NewReader(newArchive(t, test.entries...)), dir)
+			if err == nil {
+				t.Fatal("extracting succeeded")
+			}
+			if test.unsafe && !errors.Is(err, ErrUnsafePath) {
+				t.Errorf("got %v, want ErrUnsafePath", err)
+			}
+
+			// The directories created for the archive are removed again
+			if entries, err := os.ReadDir(parent); err != nil || len(entries) != 0 {
+				t.Errorf("got %v and %v, want an empty directory", entries, err)
+			}
+		})
+	}
+}
+
+func TestExtractKeepsExistingDirectory(t *testing.T) {
+	dir := t.TempDir()
+	archive := newArchive(t, entry{name: "docs/", typeflag: tar.TypeDir}, entry{name: "docs/a.txt", typeflag: tar.TypeReg, body: "a"})
+
+	// A broken archive leaves the existing directory as it was
+	if err := Extract(bytes.NewReader(archive[:len(archive)-8]), dir); err == nil {
+		t.Fatal("extracting a truncated archive succeeded")
+	}
+	if _, err := os.Stat(dir); err != nil {
+		t.Fatal(err)
+	}
+	if entries, err := os.ReadDir(dir); err != nil || len(entries) != 0 {
+		t.Errorf("got %v and %v, want an empty directory", entries, err)
+	}
+}
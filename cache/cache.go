@@ -0,0 +1,250 @@
+package cache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/deathrjj/age-gitlab-tool-tui/config"
+)
+
+// OfflineEnv is the environment variable that, if set, makes every request be
+// answered from the cache only
+const OfflineEnv = "AGE_TOOL_OFFLINE"
+
+// DefaultTTL is how long cached responses are used without asking the server,
+// unless AGE_CACHE_TTL says otherwise
+const DefaultTTL = time.Hour
+
+// Forever is a maximum age that accepts cached responses however old they are
+const Forever = time.Duration(math.MaxInt64)
+
+// ErrNotCached is returned in offline mode for a request that was never cached
+var ErrNotCached = errors.New("not available offline")
+
+// Response is the status and body of a cached or fetched response. Header is
+// only set for fetched responses.
+type Response struct {
+	StatusCode int
+	Status     string
+	Header     http.Header
+	Body       []byte
+}
+
+// Store keeps the successful responses of GET requests on disk, so that the
+// user directory and keys need not be fetched again on every launch
+type Store struct {
+	dir string
+	// TTL is how long a cached response is used without revalidating it
+	TTL time.Duration
+	// profile and credential are the scope of the cached responses, see
+	// Scoped. credential is hashed, so that tokens are not written to disk.
+	profile    string
+	credential string
+}
+
+// entry is a cached response as stored on disk
+type entry struct {
+	URL string `json:"url"`
+	// Credential is the hashed credential the response was fetched with
+	Credential string    `json:"credential,omitempty"`
+	ETag       string    `json:"etag,omitempty"`
+	Fetched    time.Time `json:"fetched"`
+	Body       []byte    `json:"body"`
+}
+
+// Default returns a store in the user's cache directory, with the TTL from
+// AGE_CACHE_TTL, such as "30m" or "24h", scoped to the active profile and
+// credential, such as the token of an API client
+func Default(credential string) (*Store, error) {
+	ttl := DefaultTTL
+	if value := os.Getenv("AGE_CACHE_TTL"); value != "" {
+		var err error
+		ttl, err = time.ParseDuration(value)
+		if err != nil || ttl < 0 {
+			return nil, fmt.Errorf("invalid AGE_CACHE_TTL %q, use a duration such as 30m or 24h", value)
+		}
+	}
+
+	// Without a cache directory responses are just not cached
+	dir, err := Dir()
+	if err == nil {
+		dir = filepath.Join(dir, "responses")
+	}
+	return New(dir, ttl).Scoped(config.Active(), credential), nil
+}
+
+// New creates a store keeping its responses in dir. An empty dir disables
+// caching.
+func New(dir string, ttl time.Duration) *Store {
+	return &Store{dir: dir, TTL: ttl}
+}
+
+// Scoped returns a store that shares the directory of s, but only the
+// responses cached for the same profile and credential, so that what one
+// token may see is not shown to another. Offline, a store without a
+// credential uses the responses of every credential of the profile, as no
+// token is needed then.
+func (s *Store) Scoped(profile, credential string) *Store {
+	scoped := *s
+	scoped.profile = profile
+	scoped.credential = ""
+	if credential != "" {
+		sum := sha256.Sum256([]byte(credential))
+		scoped.credential = hex.EncodeToString(sum[:])
+	}
+	return &scoped
+}
+
+// Dir returns the cache directory of the tool, $XDG_CACHE_HOME/age-gitlab-tool
+func Dir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "age-gitlab-tool"), nil
+}
+
+// Clear removes everything cached
+func Clear() error {
+	dir, err := Dir()
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(dir)
+}
+
+// Offline reports whether only cached responses may be used
+func Offline() bool {
+	return os.Getenv(OfflineEnv) != ""
+}
+
+// maxAgeKey is the context key of the maximum age set by WithMaxAge
+type maxAgeKey struct{}
+
+// WithMaxAge returns a context in which requests use cached responses up to
+// maxAge old without asking the server, rather than up to the TTL of the
+// store: Forever to show cached data right away, or 0 to revalidate
+// everything
+func WithMaxAge(ctx context.Context, maxAge time.Duration) context.Context {
+	return context.WithValue(ctx, maxAgeKey{}, maxAge)
+}
+
+// Get returns the response for url from the cache if it is recent enough, and
+// otherwise requests it with do, which adds any authentication and sends the
+// request. A cached response with an ETag is revalidated with If-None-Match,
+// so an unchanged response is not transferred again. In offline mode only the
+// cache is used. The request is cancelled once ctx is done.
+func (s *Store) Get(ctx context.Context, url string, do func(req *http.Request) (*http.Response, error)) (*Response, error) {
+	cached := s.load(url)
+	if Offline() {
+		if cached == nil {
+			return nil, fmt.Errorf("%s is %w", url, ErrNotCached)
+		}
+		return cached.response(), nil
+	}
+	maxAge := s.TTL
+	if value, ok := ctx.Value(maxAgeKey{}).(time.Duration); ok {
+		maxAge = value
+	}
+	if cached != nil && time.Since(cached.Fetched) < maxAge {
+		return cached.response(), nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if cached != nil && cached.ETag != "" {
+		req.Header.Set("If-None-Match", cached.ETag)
+	}
+	resp, err := do(req)
+	if err != nil {
+		return nil, err
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusNotModified && cached != nil:
+		cached.Fetched = time.Now()
+		s.save(cached)
+		return cached.response(), nil
+	case resp.StatusCode == http.StatusOK:
+		s.save(&entry{URL: url, Credential: s.credential, ETag: resp.Header.Get("ETag"), Fetched: time.Now(), Body: body})
+	}
+
+	return &Response{StatusCode: resp.StatusCode, Status: resp.Status, Header: resp.Header, Body: body}, nil
+}
+
+// path returns the file a response is cached in, one per profile
+func (s *Store) path(url string) string {
+	sum := sha256.Sum256([]byte(s.profile + "\x00" + url))
+	return filepath.Join(s.dir, hex.EncodeToString(sum[:])+".json")
+}
+
+// load returns the response for url cached with the credential of the store,
+// or with any credential if the store has none and is offline, or nil
+func (s *Store) load(url string) *entry {
+	if s.dir == "" {
+		return nil
+	}
+	data, err := os.ReadFile(s.path(url))
+	if err != nil {
+		return nil
+	}
+	var e entry
+	if err := json.Unmarshal(data, &e); err != nil || e.URL != url {
+		return nil
+	}
+	if e.Credential != s.credential && (s.credential != "" || !Offline()) {
+		return nil
+	}
+	return &e
+}
+
+// save caches a response. Failing to do so only means it is fetched again.
+func (s *Store) save(e *entry) {
+	if s.dir == "" {
+		return
+	}
+	data, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	if err := os.MkdirAll(s.dir, 0o700); err != nil {
+		return
+	}
+
+	// Write to a temporary file first so concurrent readers never see a
+	// partial response
+	file, err := os.CreateTemp(s.dir, ".response.*")
+	if err != nil {
+		return
+	}
+	defer os.Remove(file.Name())
+	_, err = file.Write(data)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		os.Rename(file.Name(), s.path(e.URL))
+	}
+}
+
+// response returns the cached response as a successful one
+func (e *entry) response() *Response {
+	return &Response{StatusCode: http.StatusOK, Status: "200 OK", Body: e.Body}
+}
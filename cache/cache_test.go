@@ -0,0 +1,252 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// server is a fake API that answers with its body and ETag, and with 304 Not
+// Modified to a matching If-None-Match
+type server struct {
+	*httptest.Server
+	body, etag string
+	// requests and revalidations count the requests, and those that came
+	// with If-None-Match
+	requests, revalidations atomic.Int32
+}
+
+func newServer(t *testing.T) *server {
+	t.Helper()
+	s := &server{body: `["alice"]`, etag: `"v1"`}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.requests.Add(1)
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+			return
+		}
+		if match := r.Header.Get("If-None-Match"); match != "" {
+			s.revalidations.Add(1)
+			if match == s.etag {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		}
+		w.Header().Set("ETag", s.etag)
+		w.Write([]byte(s.body))
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// get requests path from the server through the store
+func (s *server) get(t *testing.T, ctx context.Context, store *Store, path string) *Response {
+	t.Helper()
+	resp, err := store.Get(ctx, s.URL+path, s.Client().Do)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func TestGetUsesCacheWithinTTL(t *testing.T) {
+	s := newServer(t)
+	store := New(t.TempDir(), time.Hour)
+	ctx := context.Background()
+
+	for range 3 {
+		if resp := s.get(t, ctx, store, "/users"); resp.StatusCode != http.StatusOK || string(resp.Body) != s.body {
+			t.Fatalf("got %d %q", resp.StatusCode, resp.Body)
+		}
+	}
+	if got := s.requests.Load(); got != 1 {
+		t.Errorf("got %d requests, want 1", got)
+	}
+}
+
+func TestGetRevalidatesAfterTTL(t *testing.T) {
+	s := newServer(t)
+	store := New(t.TempDir(), 20*time.Millisecond)
+	ctx := context.Background()
+
+	s.get(t, ctx, store, "/users")
+	time.Sleep(30 * time.Millisecond)
+
+	// An unchanged response is not sent again
+	if resp := s.get(t, ctx, store, "/users"); string(resp.Body) != s.body {
+		t.Errorf("got %q after revalidating", resp.Body)
+	}
+	if got := s.revalidations.Load(); got != 1 {
+		t.Errorf("got %d revalidations, want 1", got)
+	}
+	// Revalidating counts as fetching again
+	s.get(t, ctx, store, "/users")
+	if got := s.requests.Load(); got != 2 {
+		t.Errorf("got %d requests, want 2", got)
+	}
+
+	// A changed response replaces the cached one
+	time.Sleep(30 * time.Millisecond)
+	s.body, s.etag = `["alice","bob"]`, `"v2"`
+	if resp := s.get(t, ctx, store, "/users"); string(resp.Body) != s.body {
+		t.Errorf("got %q, want the changed response", resp.Body)
+	}
+	if resp := s.get(t, ctx, store, "/users"); string(resp.Body) != s.body {
+		t.Errorf("got %q from the cache, want the changed response", resp.Body)
+	}
+}
+
+func TestGetWithMaxAge(t *testing.T) {
+	s := newServer(t)
+	store := New(t.TempDir(), 0)
+	ctx := context.Background()
+
+	s.get(t, ctx, store, "/users")
+	s.get(t, WithMaxAge(ctx, Forever), store, "/users")
+	if got := s.requests.Load(); got != 1 {
+		t.Errorf("got %d requests with Forever, want 1", got)
+	}
+
+	store.TTL = time.Hour
+	s.get(t, WithMaxAge(ctx, 0), store, "/users")
+	if got, revalidated := s.requests.Load(), s.revalidations.Load(); got != 2 || revalidated != 1 {
+		t.Errorf("got %d requests and %d revalidations with 0, want 2 and 1", got, revalidated)
+	}
+}
+
+func TestGetDoesNotCacheErrors(t *testing.T) {
+	s := newServer(t)
+	store := New(t.TempDir(), time.Hour)
+
+	for range 2 {
+		if resp := s.get(t, context.Background(), store, "/missing"); resp.StatusCode != http.StatusNotFound {
+			t.Fatalf("got status %d, want 404", resp.StatusCode)
+		}
+	}
+	if got := s.requests.Load(); got != 2 {
+		t.Errorf("got %d requests, want 2", got)
+	}
+}
+
+func TestGetWithoutDirectory(t *testing.T) {
+	s := newServer(t)
+	store := New("", time.Hour)
+
+	s.get(t, context.Background(), store, "/users")
+	s.get(t, context.Background(), store, "/users")
+	if got := s.requests.Load(); got != 2 {
+		t.Errorf("got %d requests, want 2", got)
+	}
+}
+
+func TestGetOffline(t *testing.T) {
+	s := newServer(t)
+	store := New(t.TempDir(), 0)
+	s.get(t, context.Background(), store, "/users")
+
+	t.Setenv(OfflineEnv, "1")
+	if resp := s.get(t, context.Background(), store, "/users"); string(resp.Body) != s.body {
+		t.Errorf("got %q offline", resp.Body)
+	}
+	if _, err := store.Get(context.Background(), s.URL+"/groups", s.Client().Do); !errors.Is(err, ErrNotCached) {
+		t.Errorf("got %v for an uncached response, want ErrNotCached", err)
+	}
+	if got := s.requests.Load(); got != 1 {
+		t.Errorf("got %d requests, want none offline", got-1)
+	}
+}
+
+func TestScopedStores(t *testing.T) {
+	s := newServer(t)
+	dir := t.TempDir()
+	ctx := context.Background()
+	alice := New(dir, time.Hour).Scoped("work", "alice-token")
+	s.get(t, ctx, alice, "/users")
+
+	// Responses are not shared with other tokens or profiles, not even
+	// through If-None-Match
+	tests := []struct {
+		name  string
+		store *Store
+	}{
+		{"other token", New(dir, time.Hour).Scoped("work", "bob-token")},
+		{"no token", New(dir, time.Hour).Scoped("work", "")},
+		{"other profile", New(dir, time.Hour).Scoped("home", "alice-token")},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			requests := s.requests.Load()
+			s.get(t, ctx, test.store, "/users")
+			if s.requests.Load() != requests+1 || s.revalidations.Load() != 0 {
+				t.Error("got a cached response")
+			}
+		})
+	}
+
+	// The token is not written to disk
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		data, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Contains(data, []byte("alice-token")) {
+			t.Errorf("%s contains the token", entry.Name())
+		}
+	}
+}
+
+func TestScopedStoresOffline(t *testing.T) {
+	s := newServer(t)
+	dir := t.TempDir()
+	s.get(t, context.Background(), New(dir, time.Hour).Scoped("work", "alice-token"), "/users")
+
+	// Offline, no token is needed for the responses of the profile, but
+	// another token still does not see them
+	t.Setenv(OfflineEnv, "1")
+	if _, err := New(dir, time.Hour).Scoped("work", "").Get(context.Background(), s.URL+"/users", s.Client().Do); err != nil {
+		t.Errorf("got %v offline without a token", err)
+	}
+	if _, err := New(dir, time.Hour).Scoped("work", "bob-token").Get(context.Background(), s.URL+"/users", s.Client().Do); !errors.Is(err, ErrNotCached) {
+		t.Errorf("got %v offline with another token, want ErrNotCached", err)
+	}
+	if _, err := New(dir, time.Hour).Scoped("home", "").Get(context.Background(), s.URL+"/users", s.Client().Do); !errors.Is(err, ErrNotCached) {
+		t.Errorf("got %v offline in another profile, want ErrNotCached", err)
+	}
+}
+
+func TestDefault(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	t.Setenv("AGE_CACHE_TTL", "30m")
+	store, err := Default("token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if store.TTL != 30*time.Minute {
+		t.Errorf("got TTL %v, want 30m", store.TTL)
+	}
+	dir, err := Dir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(os.Getenv("XDG_CACHE_HOME"), "age-gitlab-tool", "responses"); store.dir != filepath.Join(dir, "responses") || store.dir != want {
+		t.Errorf("got directory %s, want %s", store.dir, want)
+	}
+
+	for _, value := range []string{"soon", "-1h"} {
+		t.Setenv("AGE_CACHE_TTL", value)
+		if _, err := Default("token"); err == nil {
+			t.Errorf("got no error for AGE_CACHE_TTL=%s", value)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package cli
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/deathrjj/age-gitlab-tool-tui/cache"
+	"github.com/deathrjj/age-gitlab-tool-tui/provider"
+)
+
+const cacheUsage = `Usage:
+  age-gitlab-tool-tui cache refresh [--keys]
+  age-gitlab-tool-tui cache clear
+
+Users, groups, projects and keys fetched from GitLab, GitHub and Gitea are
+cached, so they are not fetched again for AGE_CACHE_TTL (default 1h). After
+that they are revalidated, which GitLab answers without sending unchanged
+pages again. With --offline before any command, only cached data is used.
+
+refresh fetches the users of all recipient sources again, and with --keys
+the keys of every user as well, so that anyone can be encrypted to offline.
+clear removes everything cached.
+
+Options:
+  --keys                 Also fetch the keys of every user
+`
+
+// runCache implements the cache subcommand
+func runCache(args []string) int {
+	var keys bool
+
+	fs := flag.NewFlagSet("cache", flag.ContinueOnError)
+	fs.Usage = func() { fmt.Fprint(os.Stderr, cacheUsage) }
+	fs.BoolVar(&keys, "keys", false, "")
+
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, "a cache command is required\n\n"+cacheUsage)
+		return exitUsage
+	}
+	command := args[0]
+	if err := fs.Parse(args[1:]); err != nil {
+		if err == flag.ErrHelp {
+			return exitOK
+		}
+		return exitUsage
+	}
+	if fs.NArg() > 0 || (keys && command != "refresh") {
+		fs.Usage()
+		return exitUsage
+	}
+
+	switch command {
+	case "refresh":
+		if cache.Offline() {
+			return errorf("cannot refresh the cache offline")
+		}
+		return refreshCache(keys)
+	case "clear":
+		if err := cache.Clear(); err != nil {
+			return errorf("failed to clear cache: %v", err)
+		}
+		fmt.Fprintln(os.Stderr, "Cleared the cache")
+	case "-h", "--help", "help":
+		fmt.Fprint(os.Stdout, cacheUsage)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown cache command %q\n\n%s", command, cacheUsage)
+		return exitUsage
+	}
+
+	return exitOK
+}
+
+// refreshCache revalidates the cached users, and the keys of all users if
+// keys is set
+func refreshCache(keys bool) int {
+	// Every cached response is revalidated
+	ctx := cache.WithMaxAge(context.Background(), 0)
+
+	gitlabClient, err := newGitLabClient()
+	if err != nil {
+		return errorf("%v", err)
+	}
+	providers, err := newProviders(gitlabClient)
+	if err != nil {
+		return errorf("%v", err)
+	}
+	users, err := providers.ListUsers(ctx)
+	if err != nil {
+		return errorf("failed to fetch users: %v", err)
+	}
+	fmt.Fprintf(os.Stderr, "Cached %d users\n", len(users))
+	if !keys {
+		return exitOK
+	}
+
+	// Failures are counted rather than returned, so they do not cancel the
+	// keys of the other users
+	var mu sync.Mutex
+	var done, failed int
+	err = provider.Parallel(ctx, len(users), func(ctx context.Context, i int) error {
+		_, err := providers.FetchKeys(ctx, users[i])
+
+		mu.Lock()
+		defer mu.Unlock()
+		done++
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "\nage-gitlab-tool-tui: warning: failed to fetch keys of %s: %v\n", users[i].Key(), err)
+			failed++
+		}
+		fmt.Fprintf(os.Stderr, "\rFetching keys: %d/%d", done, len(users))
+		return nil
+	})
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return errorf("%v", err)
+	}
+	if failed > 0 {
+		return errorf("failed to fetch the keys of %d of %d users", failed, len(users))
+	}
+	fmt.Fprintf(os.Stderr, "Cached the keys of %d users\n", len(users))
+
+	return exitOK
+}
@@ -0,0 +1,224 @@
+package cli
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/deathrjj/age-gitlab-tool-tui/encryption"
+	"github.com/deathrjj/age-gitlab-tool-tui/gitlab"
+	"golang.org/x/term"
+)
+
+// Exit codes returned by Run
+const (
+	exitOK    = 0
+	exitError = 1
+	exitUsage = 2
+)
+
+const usage = `Usage:
+  age-gitlab-tool-tui                      Start the interactive terminal UI
+  age-gitlab-tool-tui encrypt [options]    Encrypt to GitLab users without the UI
+  age-gitlab-tool-tui decrypt [options]    Decrypt a file or stdin without the UI
+  age-gitlab-tool-tui inspect [options]    List who a file was encrypted to
+  age-gitlab-tool-tui rekey [options]      Re-encrypt a file to a new set of recipients
+  age-gitlab-tool-tui token set|show|delete
+                                           Manage the stored GitLab token
+  age-gitlab-tool-tui login [options]      Log in to GitLab with OAuth instead of a token
+  age-gitlab-tool-tui cache refresh|clear  Update or remove the cached users and keys
+
+Every command, as well as the terminal UI, can be preceded by --profile NAME
+to use that profile of the configuration file instead of the default one, and
+by --offline to use only the users and keys cached by earlier runs.
+
+Run "age-gitlab-tool-tui <command> -h" for the options of a command.
+`
+
+// Run executes the subcommand named by args[0] and returns the process exit code
+func Run(args []string) int {
+	switch args[0] {
+	case "encrypt":
+		return runEncrypt(args[1:])
+	case "decrypt":
+		return runDecrypt(args[1:])
+	case "inspect":
+		return runInspect(args[1:])
+	case "rekey":
+		return runRekey(args[1:])
+	case "token":
+		return runToken(args[1:])
+	case "login":
+		return runLogin(args[1:])
+	case "cache":
+		return runCache(args[1:])
+	case "help", "-h", "--help":
+		fmt.Fprint(os.Stdout, usage)
+		return exitOK
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", args[0], usage)
+		return exitUsage
+	}
+}
+
+// stringList is a flag.Value that collects every occurrence of a repeated flag
+type stringList []string
+
+func (s *stringList) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringList) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
+// errorf prints an error message to stderr, followed by what to do about
+// GitLab API errors among args, and returns the generic failure exit code
+func errorf(format string, args ...interface{}) int {
+	fmt.Fprintf(os.Stderr, "age-gitlab-tool-tui: error: "+format+"\n", args...)
+	for _, arg := range args {
+		if err, ok := arg.(error); ok {
+			if advice := gitlab.Advice(err); advice != "" {
+				fmt.Fprintf(os.Stderr, "age-gitlab-tool-tui: %s\n", advice)
+			}
+		}
+	}
+	return exitError
+}
+
+// openInput opens the named file for reading, or stdin if name is empty or "-"
+func openInput(name string) (io.ReadCloser, error) {
+	if name == "" || name == "-" {
+		return io.NopCloser(os.Stdin), nil
+	}
+	return os.Open(name)
+}
+
+// openSeekableInput opens the named file for reading, or reads stdin into
+// memory if name is empty or "-", for inputs that need to be read twice
+func openSeekableInput(name string) (io.ReadSeekCloser, error) {
+	if name != "" && name != "-" {
+		return os.Open(name)
+	}
+	data, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		return nil, err
+	}
+	return nopSeekCloser{bytes.NewReader(data)}, nil
+}
+
+// nopSeekCloser adds a no-op Close method to an io.ReadSeeker
+type nopSeekCloser struct {
+	io.ReadSeeker
+}
+
+func (nopSeekCloser) Close() error { return nil }
+
+// outputFile is a destination that is either stdout or a file. Files are
+// written to a temporary file next to them which only replaces the named file
+// once the command succeeds, so that a failed command leaves it untouched and
+// the input can safely be rewritten in place.
+type outputFile struct {
+	io.Writer
+	file *os.File
+	name string
+}
+
+// createOutput creates the named file for writing, or uses stdout if name is empty or "-"
+func createOutput(name string) (*outputFile, error) {
+	if name == "" || name == "-" {
+		return &outputFile{Writer: os.Stdout}, nil
+	}
+	f, err := os.CreateTemp(filepath.Dir(name), "."+filepath.Base(name)+".*")
+	if err != nil {
+		return nil, err
+	}
+	return &outputFile{Writer: f, file: f, name: name}, nil
+}
+
+// isTerminal reports whether the output goes to a terminal
+func (o *outputFile) isTerminal() bool {
+	return o.file == nil && isTerminal(os.Stdout)
+}
+
+// finish closes the output file and moves it into place, or deletes it if the
+// command failed
+func (o *outputFile) finish(failed bool) error {
+	if o.file == nil {
+		return nil
+	}
+	err := o.file.Close()
+	if failed || err != nil {
+		os.Remove(o.file.Name())
+		return err
+	}
+	if err := os.Rename(o.file.Name(), o.name); err != nil {
+		os.Remove(o.file.Name())
+		return err
+	}
+	return nil
+}
+
+// isTerminal reports whether f is connected to a terminal
+func isTerminal(f *os.File) bool {
+	return term.IsTerminal(int(f.Fd()))
+}
+
+// largeInput is the size from which the progress of processing an input is
+// shown, as smaller ones are done before anyone could read it
+const largeInput = 10 << 20
+
+// terminalProgress returns a ProgressFunc showing the progress of a large
+// input on stderr if it is a terminal, or nil, and a function ending the
+// progress line once done
+func terminalProgress(action string) (encryption.ProgressFunc, func()) {
+	if !isTerminal(os.Stderr) {
+		return nil, func() {}
+	}
+
+	shown := false
+	progress := func(done, total int64) {
+		if !shown && (total >= 0 && total < largeInput || total < 0 && done < largeInput) {
+			return
+		}
+		shown = true
+		if total > 0 {
+			fmt.Fprintf(os.Stderr, "\r%s: %s / %s (%d%%)\033[K", action,
+				encryption.FormatSize(done), encryption.FormatSize(total), done*100/total)
+		} else {
+			fmt.Fprintf(os.Stderr, "\r%s: %s\033[K", action, encryption.FormatSize(done))
+		}
+	}
+	finish := func() {
+		if shown {
+			fmt.Fprintln(os.Stderr)
+		}
+	}
+	return progress, finish
+}
+
+// readPassphrase prompts for a passphrase on the controlling terminal, so that
+// stdin and stdout remain free for piped data
+func readPassphrase(prompt string) (string, error) {
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		if !isTerminal(os.Stdin) {
+			return "", fmt.Errorf("cannot prompt for passphrase: no terminal available")
+		}
+		tty = os.Stdin
+	} else {
+		defer tty.Close()
+	}
+
+	fmt.Fprint(os.Stderr, prompt)
+	passphrase, err := term.ReadPassword(int(tty.Fd()))
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", fmt.Errorf("failed to read passphrase: %w", err)
+	}
+	return string(passphrase), nil
+}
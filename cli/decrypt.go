@@ -0,0 +1,143 @@
+package cli
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/deathrjj/age-gitlab-tool-tui/archive"
+	"github.com/deathrjj/age-gitlab-tool-tui/encryption"
+)
+
+const decryptUsage = `Usage:
+  age-gitlab-tool-tui decrypt [-i KEY...] [-o OUTPUT | -x DIR] [INPUT]
+
+Decrypts INPUT (or stdin), either ASCII-armored or binary, and writes the
+plaintext to OUTPUT (or stdout), or unpacks an encrypted directory into DIR.
+
+Without -i, the keys in AGE_PRIVATE_KEY_PATH, the age identity files listed
+in AGE_IDENTITY_FILES and ~/.ssh/id_* are tried. Unprotected keys are tried
+first; the passphrase of a protected key is only prompted for on the
+terminal if the file is encrypted to it. If SSH_AUTH_SOCK is set, the keys
+held by ssh-agent are matched against the file's recipients as well.
+
+Options:
+  -i, --identity KEY     SSH private key or age identity file to decrypt
+                         with (repeatable)
+  -o, --output OUTPUT    Write the plaintext to OUTPUT instead of stdout
+  -x, --extract DIR      Unpack the decrypted archive of a directory into
+                         DIR, without overwriting existing files
+`
+
+// runDecrypt implements the decrypt subcommand
+func runDecrypt(args []string) int {
+	var (
+		keyPaths stringList
+		output   string
+		extract  string
+	)
+
+	fs := flag.NewFlagSet("decrypt", flag.ContinueOnError)
+	fs.Usage = func() { fmt.Fprint(os.Stderr, decryptUsage) }
+	fs.Var(&keyPaths, "i", "")
+	fs.Var(&keyPaths, "identity", "")
+	fs.StringVar(&output, "o", "", "")
+	fs.StringVar(&output, "output", "", "")
+	fs.StringVar(&extract, "x", "", "")
+	fs.StringVar(&extract, "extract", "", "")
+
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return exitOK
+		}
+		return exitUsage
+	}
+	if fs.NArg() > 1 {
+		fmt.Fprint(os.Stderr, "only one input file can be given\n\n"+decryptUsage)
+		return exitUsage
+	}
+	if output != "" && extract != "" {
+		fmt.Fprint(os.Stderr, "-o and -x cannot be used together\n\n"+decryptUsage)
+		return exitUsage
+	}
+
+	// Explicitly given keys must all be usable, discovered ones are skipped if not
+	explicit := len(keyPaths) > 0
+	if !explicit {
+		keyPaths = encryption.DiscoverIdentityPaths()
+	}
+	identities, err := encryption.LoadIdentityFiles(keyPaths, promptPassphrase, !explicit)
+	if err != nil {
+		return errorf("%v", err)
+	}
+
+	// The agent goes last, as it only reports which key would be needed
+	// when none of the key files match
+	agentIdentity, err := encryption.DialAgent()
+	if err == nil {
+		defer agentIdentity.Close()
+		identities = append(identities, agentIdentity)
+	} else if len(identities) == 0 {
+		return errorf("no identities found, use -i or set AGE_PRIVATE_KEY_PATH")
+	}
+
+	in, err := openInput(fs.Arg(0))
+	if err != nil {
+		return errorf("failed to open input: %v", err)
+	}
+	defer in.Close()
+
+	progress, finishProgress := terminalProgress("Decrypting")
+
+	if extract != "" {
+		// Unpack while decrypting, so the archive never touches the disk
+		pr, pw := io.Pipe()
+		decrypted := make(chan error, 1)
+		go func() {
+			err := encryption.Decrypt(pw, in, progress, identities...)
+			pw.CloseWithError(err)
+			decrypted <- err
+		}()
+		extractErr := archive.Extract(pr, extract)
+		// Stop decrypting if unpacking failed early
+		pr.CloseWithError(extractErr)
+
+		// Why decrypting failed is more telling than the broken archive
+		// it left, unless it only failed because unpacking did
+		err := <-decrypted
+		finishProgress()
+		if err == nil || extractErr != nil && errors.Is(err, extractErr) {
+			err = extractErr
+		}
+		if err != nil {
+			return errorf("%v", err)
+		}
+		return exitOK
+	}
+
+	out, err := createOutput(output)
+	if err != nil {
+		return errorf("failed to create output: %v", err)
+	}
+
+	err = encryption.Decrypt(out, in, progress, identities...)
+	finishProgress()
+	if closeErr := out.finish(err != nil); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return errorf("%v", err)
+	}
+
+	return exitOK
+}
+
+// promptPassphrase asks for the passphrase of a private key on the terminal
+func promptPassphrase(path string, retry bool) (string, error) {
+	if retry {
+		return readPassphrase(fmt.Sprintf("Incorrect passphrase, try again for %s: ", path))
+	}
+	return readPassphrase(fmt.Sprintf("Enter passphrase for %s: ", path))
+}
@@ -0,0 +1,311 @@
+package cli
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"filippo.io/age"
+	"github.com/deathrjj/age-gitlab-tool-tui/encryption"
+	"github.com/deathrjj/age-gitlab-tool-tui/gitlab"
+	"github.com/deathrjj/age-gitlab-tool-tui/models"
+	"github.com/deathrjj/age-gitlab-tool-tui/policy"
+	"github.com/deathrjj/age-gitlab-tool-tui/provider"
+	"github.com/deathrjj/age-gitlab-tool-tui/sources"
+)
+
+const encryptUsage = `Usage:
+  age-gitlab-tool-tui encrypt [-r USER...] [-g GROUP...] [-p PROJECT...]
+                             [--min-access LEVEL] [-a] [-o OUTPUT]
+                             [--trust-new-keys] [--skip-unusable-keys] [INPUT]
+
+Encrypts INPUT (or stdin) to the SSH keys of the given GitLab users, groups
+and projects and writes the result to OUTPUT (or stdout). If INPUT is a
+directory, a gzip compressed tar archive of it is encrypted, which decrypt
+--extract unpacks again. Users of GitHub, Gitea and the local recipient
+sources can be given with -r as well. GITLAB_URL and GITLAB_TOKEN must be set
+for GitLab groups, projects and users, while plain usernames are only looked
+up in GitLab if GITLAB_URL is set.
+
+The keys of every user are remembered. If they changed since the last time,
+the changes are shown, and new keys have to be confirmed on the terminal or
+with --trust-new-keys before they are encrypted to. Expired keys, keys only
+meant for signing and key types age does not support, such as ecdsa and
+security keys, are skipped with a warning. Users left without any usable key
+are only left out if confirmed on the terminal or with --skip-unusable-keys.
+
+Options:
+  -r, --recipient USER   Username, SOURCE/USERNAME if several sources have a
+                         user of that name, or native age recipient
+                         ("age1...") to encrypt to (repeatable)
+  -g, --group GROUP      GitLab group path or ID whose members, including
+                         those of its subgroups, to encrypt to (repeatable)
+  -p, --project PROJECT  GitLab project path or ID whose members to encrypt
+                         to (repeatable)
+  --min-access LEVEL     Minimum access level of project members: guest,
+                         reporter, developer, maintainer or owner
+                         (default developer)
+  -a, --armor            Write ASCII-armored output instead of binary
+  -o, --output OUTPUT    Write the result to OUTPUT instead of stdout
+  --trust-new-keys       Encrypt to keys that changed since they were last
+                         seen without asking
+  --skip-unusable-keys   Leave out users without usable keys without asking
+`
+
+// runEncrypt implements the encrypt subcommand
+func runEncrypt(args []string) int {
+	var (
+		usernames stringList
+		groups    stringList
+		projects  stringList
+		minAccess string
+		armored   bool
+		output    string
+		trustNew  bool
+		skip      bool
+	)
+
+	fs := flag.NewFlagSet("encrypt", flag.ContinueOnError)
+	fs.Usage = func() { fmt.Fprint(os.Stderr, encryptUsage) }
+	fs.Var(&usernames, "r", "")
+	fs.Var(&usernames, "recipient", "")
+	fs.Var(&groups, "g", "")
+	fs.Var(&groups, "group", "")
+	fs.Var(&projects, "p", "")
+	fs.Var(&projects, "project", "")
+	fs.StringVar(&minAccess, "min-access", "developer", "")
+	fs.BoolVar(&armored, "a", false, "")
+	fs.BoolVar(&armored, "armor", false, "")
+	fs.StringVar(&output, "o", "", "")
+	fs.StringVar(&output, "output", "", "")
+	fs.BoolVar(&trustNew, "trust-new-keys", false, "")
+	fs.BoolVar(&skip, "skip-unusable-keys", false, "")
+
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return exitOK
+		}
+		return exitUsage
+	}
+	if len(usernames) == 0 && len(groups) == 0 && len(projects) == 0 {
+		fmt.Fprint(os.Stderr, "at least one recipient is required\n\n"+encryptUsage)
+		return exitUsage
+	}
+	minAccessLevel, err := models.ParseAccessLevel(minAccess)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n\n%s", err, encryptUsage)
+		return exitUsage
+	}
+	if fs.NArg() > 1 {
+		fmt.Fprint(os.Stderr, "only one input file can be given\n\n"+encryptUsage)
+		return exitUsage
+	}
+
+	var gitlabClient *gitlab.Client
+	if gitlabRequested(usernames, groups, projects) {
+		gitlabClient, err = newGitLabClient()
+		if err != nil {
+			return errorf("%v", err)
+		}
+	}
+
+	providers, err := newProviders(gitlabClient)
+	if err != nil {
+		return errorf("%v", err)
+	}
+
+	recipients, err := resolveRecipients(gitlabClient, providers, nil, usernames, groups, projects, minAccessLevel, nil, trustNew, skip)
+	if err != nil {
+		return errorf("%v", err)
+	}
+	if len(recipients) == 0 {
+		return errorf("none of the selected users have usable SSH or age keys")
+	}
+
+	input := fs.Arg(0)
+	if input != "" && input != "-" {
+		if _, err := os.Stat(input); err != nil {
+			return errorf("failed to open input: %v", err)
+		}
+	}
+
+	out, err := createOutput(output)
+	if err != nil {
+		return errorf("failed to create output: %v", err)
+	}
+	if !armored && out.isTerminal() {
+		out.finish(true)
+		return errorf("refusing to write binary output to a terminal, use -a or -o")
+	}
+
+	// Directories are archived, other inputs are encrypted as they are
+	progress, finishProgress := terminalProgress("Encrypting")
+	if input == "" || input == "-" {
+		err = encryption.Encrypt(out, encryption.NewProgressReader(os.Stdin, -1, progress), recipients, armored)
+	} else {
+		err = encryption.EncryptPath(out, input, recipients, armored, progress)
+	}
+	finishProgress()
+	if closeErr := out.finish(err != nil); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return errorf("encryption failed: %v", err)
+	}
+
+	return exitOK
+}
+
+// resolveRecipients returns the age recipients for the given usernames or
+// native age recipients, and the members of the given groups and projects,
+// leaving out the users whose keys are in exclude. users is listed from
+// providers if nil and needed. Changed keys are only encrypted to if confirmed,
+// or if trustNew is set, and users without usable keys are only left out if
+// confirmed, or if skipUnusable is set.
+func resolveRecipients(gitlabClient *gitlab.Client, providers provider.Set, users []models.User,
+	usernames, groups, projects []string, minAccess models.AccessLevel, exclude map[string]bool,
+	trustNew, skipUnusable bool) ([]age.Recipient, error) {
+	ctx := context.Background()
+	keyPolicy, err := policy.FromEnvironment()
+	if err != nil {
+		return nil, err
+	}
+
+	// Native age recipients can be given directly instead of a username
+	var recipients []age.Recipient
+	var names []string
+	for _, username := range usernames {
+		if recipient, err := age.ParseX25519Recipient(username); err == nil {
+			recipients = append(recipients, recipient)
+			continue
+		}
+		names = append(names, username)
+	}
+
+	var selected []models.User
+	if len(names) > 0 {
+		if users == nil {
+			if users, err = providers.ListUsers(ctx); err != nil {
+				return nil, fmt.Errorf("failed to fetch users: %w", err)
+			}
+		}
+		named, err := resolveUsernames(users, names)
+		if err != nil {
+			return nil, err
+		}
+		selected = append(selected, named...)
+	}
+	for _, group := range groups {
+		members, err := gitlabClient.FetchGroupMembers(ctx, group)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch members of group %s: %w", group, err)
+		}
+		selected = append(selected, members...)
+	}
+	for _, project := range projects {
+		members, err := gitlabClient.FetchProjectMembers(ctx, project, minAccess)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch members of project %s: %w", project, err)
+		}
+		selected = append(selected, members...)
+	}
+
+	// Users may be selected more than once, through a group and by name
+	seen := make(map[string]bool)
+	var unique []models.User
+	for _, user := range selected {
+		if !seen[user.Key()] && !exclude[user.Key()] {
+			seen[user.Key()] = true
+			unique = append(unique, user)
+		}
+	}
+
+	userKeys, err := encryption.FetchKeys(ctx, unique, providers)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch recipient keys: %w", err)
+	}
+	if err := verifyKeys(userKeys, trustNew); err != nil {
+		return nil, err
+	}
+	userKeys, reports := keyPolicy.Apply(userKeys)
+	if err := checkPolicy(reports); err != nil {
+		return nil, err
+	}
+	userRecipients, usage := encryption.ParseUserKeys(userKeys)
+	if err := checkKeyUsage(usage, skipUnusable); err != nil {
+		return nil, err
+	}
+
+	return append(recipients, userRecipients...), nil
+}
+
+// resolveUsernames returns the users with the given names, ignoring case. A
+// name is either a plain username or, to tell apart users of different
+// sources, the source and username separated by a slash, like
+// "file:team.txt/alice".
+func resolveUsernames(users []models.User, usernames []string) ([]models.User, error) {
+	var resolved []models.User
+	for _, username := range usernames {
+		var matches []models.User
+		for _, user := range users {
+			if strings.EqualFold(user.Key(), username) {
+				matches = []models.User{user}
+				break
+			}
+			if strings.EqualFold(user.Username, username) {
+				matches = append(matches, user)
+			}
+		}
+
+		switch len(matches) {
+		case 0:
+			return nil, fmt.Errorf("unknown user %q", username)
+		case 1:
+			resolved = append(resolved, matches[0])
+		default:
+			keys := make([]string, len(matches))
+			for i, user := range matches {
+				keys[i] = user.Key()
+			}
+			return nil, fmt.Errorf("user %q is ambiguous, use one of %s", username, strings.Join(keys, ", "))
+		}
+	}
+
+	return resolved, nil
+}
+
+// newProviders returns the GitLab client, unless it is nil, together with the
+// other providers configured in the environment
+func newProviders(gitlabClient *gitlab.Client) (provider.Set, error) {
+	providers, err := sources.FromEnvironment()
+	if err != nil {
+		return nil, err
+	}
+	if gitlabClient == nil {
+		return providers, nil
+	}
+	return append(provider.Set{gitlabClient}, providers...), nil
+}
+
+// gitlabRequested reports whether the recipients include GitLab users, groups
+// or projects, so a GitLab client is needed. Groups, projects and "gitlab/"
+// usernames always are, native age recipients and users of other sources are
+// not. Plain usernames may be GitLab users if GITLAB_URL is set.
+func gitlabRequested(usernames, groups, projects []string) bool {
+	if len(groups) > 0 || len(projects) > 0 {
+		return true
+	}
+	configured := os.Getenv("GITLAB_URL") != ""
+	for _, username := range usernames {
+		if _, err := age.ParseX25519Recipient(username); err == nil {
+			continue
+		}
+		source, _, qualified := strings.Cut(username, "/")
+		if strings.EqualFold(source, gitlab.Source) || !qualified && configured {
+			return true
+		}
+	}
+	return false
+}
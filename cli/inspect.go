@@ -0,0 +1,88 @@
+package cli
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/deathrjj/age-gitlab-tool-tui/encryption"
+	"github.com/deathrjj/age-gitlab-tool-tui/provider"
+	"github.com/deathrjj/age-gitlab-tool-tui/sources"
+)
+
+const inspectUsage = `Usage:
+  age-gitlab-tool-tui inspect [-l] [INPUT]
+
+Lists who INPUT (or stdin), either ASCII-armored or binary, was encrypted to,
+without needing any private key. SSH recipients are matched against the keys
+of the GitLab users if GITLAB_URL and GITLAB_TOKEN are set, and of the GitHub,
+Gitea and local users that are configured. Native age
+recipients do not reveal their public key and cannot be identified.
+
+Options:
+  -l, --list             Print one recipient per line instead of a summary
+`
+
+// runInspect implements the inspect subcommand
+func runInspect(args []string) int {
+	var list bool
+
+	fs := flag.NewFlagSet("inspect", flag.ContinueOnError)
+	fs.Usage = func() { fmt.Fprint(os.Stderr, inspectUsage) }
+	fs.BoolVar(&list, "l", false, "")
+	fs.BoolVar(&list, "list", false, "")
+
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return exitOK
+		}
+		return exitUsage
+	}
+	if fs.NArg() > 1 {
+		fmt.Fprint(os.Stderr, "only one input file can be given\n\n"+inspectUsage)
+		return exitUsage
+	}
+
+	in, err := openInput(fs.Arg(0))
+	if err != nil {
+		return errorf("failed to open input: %v", err)
+	}
+	defer in.Close()
+
+	recipients, err := encryption.ReadRecipients(in)
+	if err != nil {
+		return errorf("%v", err)
+	}
+
+	// Without GitLab access the recipients are still listed, just not named
+	// unless they belong to another provider
+	providers, err := sources.FromEnvironment()
+	if err != nil {
+		return errorf("%v", err)
+	}
+	if gitlabClient, err := newGitLabClient(); err != nil {
+		fmt.Fprintf(os.Stderr, "age-gitlab-tool-tui: warning: %v, recipients cannot be matched to GitLab users\n", err)
+	} else {
+		providers = append(provider.Set{gitlabClient}, providers...)
+	}
+	if len(providers) > 0 {
+		users, err := providers.ListUsers(context.Background())
+		if err != nil {
+			return errorf("failed to fetch users: %v", err)
+		}
+		if err := encryption.IdentifyRecipients(context.Background(), recipients, users, providers); err != nil {
+			return errorf("%v", err)
+		}
+	}
+
+	if !list {
+		fmt.Println(encryption.FormatRecipients(recipients))
+		return exitOK
+	}
+	for _, recipient := range recipients {
+		fmt.Println(encryption.DescribeRecipient(recipient))
+	}
+
+	return exitOK
+}
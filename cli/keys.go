@@ -0,0 +1,47 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/deathrjj/age-gitlab-tool-tui/encryption"
+	"github.com/deathrjj/age-gitlab-tool-tui/policy"
+)
+
+// checkKeyUsage prints a warning for every user some of whose keys are
+// skipped. Users left without any usable key could not decrypt, so leaving
+// them out has to be confirmed on the terminal, unless skipUnusable is set.
+func checkKeyUsage(usage []encryption.KeyUsage, skipUnusable bool) error {
+	var unusable []string
+	for _, u := range encryption.SkippedKeys(usage) {
+		fmt.Fprintf(os.Stderr, "age-gitlab-tool-tui: warning: %s\n", u)
+		if !u.Usable() {
+			unusable = append(unusable, u.User.Key())
+		}
+	}
+	if len(unusable) == 0 || skipUnusable {
+		return nil
+	}
+
+	confirmed, err := confirm("Encrypt to the other recipients without them? [y/N] ")
+	if err != nil {
+		return fmt.Errorf("no usable keys for %s, use --skip-unusable-keys to encrypt to the other recipients",
+			strings.Join(unusable, ", "))
+	}
+	if !confirmed {
+		return fmt.Errorf("not encrypting without %s", strings.Join(unusable, ", "))
+	}
+	return nil
+}
+
+// checkPolicy prints a warning for every user some of whose keys the key
+// policy forbids, and fails if any user has too few keys left
+func checkPolicy(reports []policy.Report) error {
+	for _, report := range reports {
+		if !report.Rejected() {
+			fmt.Fprintf(os.Stderr, "age-gitlab-tool-tui: warning: key policy: %s\n", report)
+		}
+	}
+	return policy.Enforce(reports)
+}
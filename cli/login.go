@@ -0,0 +1,111 @@
+package cli
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/deathrjj/age-gitlab-tool-tui/config"
+	"github.com/deathrjj/age-gitlab-tool-tui/gitlab"
+	"github.com/deathrjj/age-gitlab-tool-tui/secrets"
+	"golang.org/x/oauth2"
+)
+
+const loginUsage = `Usage:
+  age-gitlab-tool-tui login [--device] [--client-id ID] [--redirect-uri URI]
+
+Logs in to the GitLab instance in GITLAB_URL with OAuth instead of a personal
+access token, and stores the token for the active profile like the token
+command does. The token is refreshed automatically when it expires, and is
+used whenever GITLAB_TOKEN is not set and no token is stored.
+
+This needs an OAuth application in GitLab (User Settings > Applications), not
+marked as confidential, with the read_api scope. By default the browser is
+opened to log in, and GitLab redirects back to a local address that must be
+one of the redirect URIs of the application. With --device, a code is shown
+instead that can be entered on any device, which needs a newer GitLab.
+
+Options:
+  --device               Use the device authorization grant
+  --client-id ID         The application ID (default $GITLAB_OAUTH_CLIENT_ID)
+  --redirect-uri URI     The local redirect URI to listen on
+                         (default $GITLAB_OAUTH_REDIRECT_URI or
+                         ` + gitlab.DefaultRedirectURI + `)
+`
+
+// loginTimeout is how long to wait for the user to log in
+const loginTimeout = 5 * time.Minute
+
+// runLogin implements the login subcommand
+func runLogin(args []string) int {
+	var device bool
+	var clientID, redirectURI string
+
+	fs := flag.NewFlagSet("login", flag.ContinueOnError)
+	fs.Usage = func() { fmt.Fprint(os.Stderr, loginUsage) }
+	fs.BoolVar(&device, "device", false, "")
+	fs.StringVar(&clientID, "client-id", os.Getenv("GITLAB_OAUTH_CLIENT_ID"), "")
+	fs.StringVar(&redirectURI, "redirect-uri", os.Getenv("GITLAB_OAUTH_REDIRECT_URI"), "")
+
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return exitOK
+		}
+		return exitUsage
+	}
+	if fs.NArg() > 0 {
+		fs.Usage()
+		return exitUsage
+	}
+
+	baseURL := os.Getenv("GITLAB_URL")
+	if baseURL == "" {
+		return errorf("GITLAB_URL not set")
+	}
+	if clientID == "" {
+		return errorf("no OAuth application ID, use --client-id or set GITLAB_OAUTH_CLIENT_ID")
+	}
+	if redirectURI == "" {
+		redirectURI = gitlab.DefaultRedirectURI
+	}
+	warnInsecure()
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+	ctx, cancelTimeout := context.WithTimeout(ctx, loginTimeout)
+	defer cancelTimeout()
+
+	cfg := gitlab.OAuthConfig(baseURL, clientID, redirectURI, nil)
+	var token *oauth2.Token
+	var err error
+	if device {
+		token, err = gitlab.LoginWithDevice(ctx, cfg, func(auth *oauth2.DeviceAuthResponse) {
+			if auth.VerificationURIComplete != "" {
+				fmt.Fprintf(os.Stderr, "Open %s to log in, or enter the code %s at %s\n",
+					auth.VerificationURIComplete, auth.UserCode, auth.VerificationURI)
+			} else {
+				fmt.Fprintf(os.Stderr, "Enter the code %s at %s to log in\n", auth.UserCode, auth.VerificationURI)
+			}
+		})
+	} else {
+		token, err = gitlab.LoginWithBrowser(ctx, cfg, func(authURL string) {
+			fmt.Fprintf(os.Stderr, "Open this URL in your browser to log in, if it does not open by itself:\n\n  %s\n\n", authURL)
+			gitlab.OpenBrowser(authURL)
+		})
+	}
+	if err != nil {
+		return errorf("%v", err)
+	}
+
+	profile := config.Active()
+	where, err := secrets.SaveOAuthToken(profile, &gitlab.OAuthCredential{ClientID: clientID, Token: token})
+	if err != nil {
+		return errorf("failed to store token: %v", err)
+	}
+	fmt.Fprintf(os.Stderr, "Logged in to %s and stored the token of profile %q in the %s\n", baseURL, profile, where)
+
+	return exitOK
+}
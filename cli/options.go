@@ -0,0 +1,62 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/deathrjj/age-gitlab-tool-tui/cache"
+	"github.com/deathrjj/age-gitlab-tool-tui/config"
+)
+
+// ApplyOptions removes the leading global options from args and applies them.
+// --profile NAME applies that profile of the configuration file, otherwise
+// the one in AGE_TOOL_PROFILE or the default profile is applied. --offline
+// makes everything be read from the cache. It returns the remaining arguments.
+func ApplyOptions(args []string) ([]string, error) {
+	var name string
+	for len(args) > 0 {
+		switch {
+		case args[0] == "--profile":
+			if len(args) < 2 || args[1] == "" {
+				return nil, fmt.Errorf("--profile needs a profile name")
+			}
+			name, args = args[1], args[2:]
+		case strings.HasPrefix(args[0], "--profile="):
+			name, args = strings.TrimPrefix(args[0], "--profile="), args[1:]
+			if name == "" {
+				return nil, fmt.Errorf("--profile needs a profile name")
+			}
+		case args[0] == "--offline":
+			os.Setenv(cache.OfflineEnv, "1")
+			args = args[1:]
+		default:
+			return applyProfile(name, args)
+		}
+	}
+	return applyProfile(name, args)
+}
+
+// applyProfile applies the named profile, or the one in AGE_TOOL_PROFILE or
+// the default profile if name is empty, and returns args
+func applyProfile(name string, args []string) ([]string, error) {
+	cfg, err := config.Load()
+	if err != nil {
+		return nil, err
+	}
+	selected, explicit, err := cfg.Select(name)
+	if err != nil {
+		return nil, err
+	}
+	if selected == "" {
+		return args, nil
+	}
+
+	// A profile chosen explicitly takes precedence over the environment,
+	// while the default profile only fills in what is not set
+	if err := cfg.Apply(selected, explicit); err != nil {
+		return nil, err
+	}
+
+	return args, nil
+}
@@ -0,0 +1,238 @@
+package cli
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/deathrjj/age-gitlab-tool-tui/encryption"
+	"github.com/deathrjj/age-gitlab-tool-tui/gitlab"
+	"github.com/deathrjj/age-gitlab-tool-tui/models"
+)
+
+const rekeyUsage = `Usage:
+  age-gitlab-tool-tui rekey [-i KEY...] [-r USER...] [-g GROUP...] [-p PROJECT...]
+                           [--remove USER...] [--min-access LEVEL] [-a]
+                           [-o OUTPUT] [--trust-new-keys]
+                           [--skip-unusable-keys] [INPUT]
+
+Re-encrypts INPUT (or stdin) to a new set of recipients and writes the result
+to OUTPUT (or stdout). OUTPUT may be the same file as INPUT. Files are
+streamed, while stdin is read into memory first.
+
+The file is decrypted with the given keys, or the discovered ones as for
+decrypt. Its current recipients are matched to the GitLab, GitHub, Gitea and
+local users, which are kept unless removed, and are encrypted to with their
+current SSH and age keys. Recipients that
+cannot be matched to a user lose access unless they are added again.
+GitLab users are only matched if GITLAB_URL and GITLAB_TOKEN are set, which
+is required for GitLab groups and projects.
+
+Options:
+  -i, --identity KEY     SSH private key or age identity file to decrypt
+                         with (repeatable)
+  -r, --recipient USER   Username, SOURCE/USERNAME, or native age recipient
+                         ("age1...") to add (repeatable)
+  -g, --group GROUP      GitLab group path or ID whose members to add
+                         (repeatable)
+  -p, --project PROJECT  GitLab project path or ID whose members to add
+                         (repeatable)
+  --remove USER          Username or SOURCE/USERNAME to remove (repeatable)
+  --min-access LEVEL     Minimum access level of project members: guest,
+                         reporter, developer, maintainer or owner
+                         (default developer)
+  -a, --armor            Write ASCII-armored output, which is the default
+                         if INPUT is ASCII-armored
+  -o, --output OUTPUT    Write the result to OUTPUT instead of stdout
+  --trust-new-keys       Encrypt to keys that changed since they were last
+                         seen without asking, as for encrypt
+  --skip-unusable-keys   Leave out users without usable keys without asking,
+                         as for encrypt
+`
+
+// runRekey implements the rekey subcommand
+func runRekey(args []string) int {
+	var (
+		keyPaths  stringList
+		usernames stringList
+		groups    stringList
+		projects  stringList
+		removed   stringList
+		minAccess string
+		armored   bool
+		output    string
+		trustNew  bool
+		skip      bool
+	)
+
+	fs := flag.NewFlagSet("rekey", flag.ContinueOnError)
+	fs.Usage = func() { fmt.Fprint(os.Stderr, rekeyUsage) }
+	fs.Var(&keyPaths, "i", "")
+	fs.Var(&keyPaths, "identity", "")
+	fs.Var(&usernames, "r", "")
+	fs.Var(&usernames, "recipient", "")
+	fs.Var(&groups, "g", "")
+	fs.Var(&groups, "group", "")
+	fs.Var(&projects, "p", "")
+	fs.Var(&projects, "project", "")
+	fs.Var(&removed, "remove", "")
+	fs.StringVar(&minAccess, "min-access", "developer", "")
+	fs.BoolVar(&armored, "a", false, "")
+	fs.BoolVar(&armored, "armor", false, "")
+	fs.StringVar(&output, "o", "", "")
+	fs.StringVar(&output, "output", "", "")
+	fs.BoolVar(&trustNew, "trust-new-keys", false, "")
+	fs.BoolVar(&skip, "skip-unusable-keys", false, "")
+
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return exitOK
+		}
+		return exitUsage
+	}
+	minAccessLevel, err := models.ParseAccessLevel(minAccess)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n\n%s", err, rekeyUsage)
+		return exitUsage
+	}
+	if fs.NArg() > 1 {
+		fmt.Fprint(os.Stderr, "only one input file can be given\n\n"+rekeyUsage)
+		return exitUsage
+	}
+
+	// The current recipients may be GitLab users if GitLab is configured
+	var gitlabClient *gitlab.Client
+	if gitlabRequested(usernames, groups, projects) || os.Getenv("GITLAB_URL") != "" {
+		gitlabClient, err = newGitLabClient()
+		if err != nil {
+			return errorf("%v", err)
+		}
+	}
+
+	// The input is read twice, for its recipients and to decrypt it
+	in, err := openSeekableInput(fs.Arg(0))
+	if err != nil {
+		return errorf("failed to open input: %v", err)
+	}
+	defer in.Close()
+	start := make([]byte, 1024)
+	n, _ := io.ReadFull(in, start)
+	armored = armored || encryption.IsArmored(start[:n])
+
+	explicit := len(keyPaths) > 0
+	if !explicit {
+		keyPaths = encryption.DiscoverIdentityPaths()
+	}
+	identities, err := encryption.LoadIdentityFiles(keyPaths, promptPassphrase, !explicit)
+	if err != nil {
+		return errorf("%v", err)
+	}
+	if agentIdentity, err := encryption.DialAgent(); err == nil {
+		defer agentIdentity.Close()
+		identities = append(identities, agentIdentity)
+	} else if len(identities) == 0 {
+		return errorf("no identities found, use -i or set AGE_PRIVATE_KEY_PATH")
+	}
+
+	if _, err := in.Seek(0, io.SeekStart); err != nil {
+		return errorf("failed to read input: %v", err)
+	}
+	current, err := encryption.ReadRecipients(in)
+	if err != nil {
+		return errorf("%v", err)
+	}
+	if _, err := in.Seek(0, io.SeekStart); err != nil {
+		return errorf("failed to read input: %v", err)
+	}
+
+	// Decrypt and encrypt again at the same time, so the plaintext is never
+	// held in memory or written to disk as a whole. Decryption is started
+	// first, so that no output is written unless one of the keys matches.
+	progress, finishProgress := terminalProgress("Re-encrypting")
+	pr, pw := io.Pipe()
+	decryptErr := make(chan error, 1)
+	go func() {
+		err := encryption.Decrypt(pw, in, progress, identities...)
+		pw.CloseWithError(err)
+		decryptErr <- err
+	}()
+	defer pr.Close()
+	plaintext := bufio.NewReader(pr)
+	if _, err := plaintext.Peek(1); err != nil && err != io.EOF {
+		return errorf("%v", err)
+	}
+
+	providers, err := newProviders(gitlabClient)
+	if err != nil {
+		return errorf("%v", err)
+	}
+	users, err := providers.ListUsers(context.Background())
+	if err != nil {
+		return errorf("failed to fetch users: %v", err)
+	}
+	if err := encryption.IdentifyRecipients(context.Background(), current, users, providers); err != nil {
+		return errorf("%v", err)
+	}
+
+	// Keep the current recipients that belong to known users
+	kept := make(map[string]bool)
+	for _, recipient := range current {
+		if !recipient.Known() {
+			fmt.Fprintf(os.Stderr, "age-gitlab-tool-tui: warning: dropping %s\n", encryption.DescribeRecipient(recipient))
+			continue
+		}
+		key := models.User{Username: recipient.Username, Source: recipient.Source}.Key()
+		if !kept[key] {
+			kept[key] = true
+			usernames = append(usernames, key)
+		}
+	}
+
+	removedUsers, err := resolveUsernames(users, removed)
+	if err != nil {
+		return errorf("%v", err)
+	}
+	exclude := make(map[string]bool)
+	for _, user := range removedUsers {
+		exclude[user.Key()] = true
+	}
+
+	recipients, err := resolveRecipients(gitlabClient, providers, users, usernames, groups, projects, minAccessLevel, exclude, trustNew, skip)
+	if err != nil {
+		return errorf("%v", err)
+	}
+	if len(recipients) == 0 {
+		return errorf("no recipients left to encrypt to")
+	}
+
+	out, err := createOutput(output)
+	if err != nil {
+		return errorf("failed to create output: %v", err)
+	}
+	if !armored && out.isTerminal() {
+		out.finish(true)
+		return errorf("refusing to write binary output to a terminal, use -a or -o")
+	}
+
+	err = encryption.Encrypt(out, plaintext, recipients, armored)
+	pr.CloseWithError(err)
+	dErr := <-decryptErr
+	finishProgress()
+	if dErr != nil {
+		out.finish(true)
+		return errorf("%v", dErr)
+	}
+	if closeErr := out.finish(err != nil); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return errorf("encryption failed: %v", err)
+	}
+
+	fmt.Fprintf(os.Stderr, "Re-encrypted to %d keys\n", len(recipients))
+
+	return exitOK
+}
@@ -0,0 +1,170 @@
+package cli
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/deathrjj/age-gitlab-tool-tui/cache"
+	"github.com/deathrjj/age-gitlab-tool-tui/config"
+	"github.com/deathrjj/age-gitlab-tool-tui/gitlab"
+	"github.com/deathrjj/age-gitlab-tool-tui/secrets"
+)
+
+const tokenUsage = `Usage:
+  age-gitlab-tool-tui token set [--stdin]
+  age-gitlab-tool-tui token show
+  age-gitlab-tool-tui token delete
+
+Stores, shows (masked) or deletes the GitLab token of the active profile
+("default" unless chosen with --profile). The token is kept in the system
+keyring, such as the Secret Service on Linux, or where none is available in a
+file encrypted with age to your own SSH key or age identity, which is unlocked
+like a file being decrypted. The stored token is used whenever GITLAB_TOKEN is
+not set. show falls back to the OAuth login stored by the login command if
+there is no token, and delete removes both.
+
+Options:
+  --stdin                Read the token to set from stdin instead of
+                         prompting for it
+`
+
+// runToken implements the token subcommand
+func runToken(args []string) int {
+	var fromStdin bool
+
+	fs := flag.NewFlagSet("token", flag.ContinueOnError)
+	fs.Usage = func() { fmt.Fprint(os.Stderr, tokenUsage) }
+	fs.BoolVar(&fromStdin, "stdin", false, "")
+
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, "a token command is required\n\n"+tokenUsage)
+		return exitUsage
+	}
+	command := args[0]
+	if err := fs.Parse(args[1:]); err != nil {
+		if err == flag.ErrHelp {
+			return exitOK
+		}
+		return exitUsage
+	}
+	if fs.NArg() > 0 || (fromStdin && command != "set") {
+		fs.Usage()
+		return exitUsage
+	}
+
+	profile := config.Active()
+	switch command {
+	case "set":
+		token, err := readToken(fromStdin)
+		if err != nil {
+			return errorf("%v", err)
+		}
+		if token == "" {
+			return errorf("the token is empty")
+		}
+		where, err := secrets.SaveToken(profile, token)
+		if err != nil {
+			return errorf("failed to store token: %v", err)
+		}
+		fmt.Fprintf(os.Stderr, "Stored the GitLab token of profile %q in the %s\n", profile, where)
+
+		// A token in the configuration file is applied to GITLAB_TOKEN and
+		// would keep the stored one from being used
+		if cfg, err := config.Load(); err == nil && cfg.Profiles[profile] != nil && cfg.Profiles[profile].GitLabToken != "" {
+			fmt.Fprintf(os.Stderr, "age-gitlab-tool-tui: warning: profile %q still has a gitlab_token in the "+
+				"configuration file, which takes precedence, remove it to use the stored token\n", profile)
+		}
+	case "show":
+		token, where, err := secrets.LoadToken(profile, promptPassphrase)
+		if errors.Is(err, secrets.ErrNotFound) {
+			return showOAuthToken(profile)
+		}
+		if err != nil {
+			return errorf("%v", err)
+		}
+		fmt.Printf("%s (profile %q, %s)\n", secrets.Mask(token), profile, where)
+	case "delete":
+		err := secrets.DeleteToken(profile)
+		if errors.Is(err, secrets.ErrNotFound) {
+			return errorf("no GitLab token or login stored for profile %q", profile)
+		}
+		if err != nil {
+			return errorf("%v", err)
+		}
+		fmt.Fprintf(os.Stderr, "Deleted the GitLab token and login of profile %q\n", profile)
+	case "-h", "--help", "help":
+		fmt.Fprint(os.Stdout, tokenUsage)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown token command %q\n\n%s", command, tokenUsage)
+		return exitUsage
+	}
+
+	return exitOK
+}
+
+// showOAuthToken prints the masked access token of the OAuth login stored for
+// a profile, if there is one
+func showOAuthToken(profile string) int {
+	credential, where, err := secrets.LoadOAuthToken(profile, promptPassphrase)
+	if errors.Is(err, secrets.ErrNotFound) {
+		return errorf("no GitLab token or login stored for profile %q", profile)
+	}
+	if err != nil {
+		return errorf("%v", err)
+	}
+
+	expiry := "does not expire"
+	if !credential.Token.Expiry.IsZero() {
+		expiry = "expires " + credential.Token.Expiry.Local().Format(time.DateTime)
+	}
+	fmt.Printf("%s (profile %q, OAuth login, %s, %s)\n", secrets.Mask(credential.Token.AccessToken), profile, expiry, where)
+	return exitOK
+}
+
+// readToken reads the token to store from the first line of stdin, or
+// prompts for it on the terminal
+func readToken(fromStdin bool) (string, error) {
+	if !fromStdin {
+		token, err := readPassphrase("GitLab token: ")
+		if err != nil {
+			return "", fmt.Errorf("%v, use --stdin", err)
+		}
+		return strings.TrimSpace(token), nil
+	}
+
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return "", fmt.Errorf("failed to read token from stdin: %v", err)
+	}
+	return strings.TrimSpace(line), nil
+}
+
+// newGitLabClient creates the GitLab client. If GITLAB_TOKEN is not set, the
+// token or OAuth login stored for the active profile is used, which may ask
+// for the passphrase of the key its file is encrypted to. Offline no token is
+// needed.
+func newGitLabClient() (*gitlab.Client, error) {
+	warnInsecure()
+	if os.Getenv("GITLAB_URL") != "" && os.Getenv("GITLAB_TOKEN") == "" && !cache.Offline() {
+		client, err := secrets.NewGitLabClient(config.Active(), promptPassphrase, func(err error) {
+			fmt.Fprintf(os.Stderr, "age-gitlab-tool-tui: warning: %v\n", err)
+		})
+		if !errors.Is(err, secrets.ErrNotFound) {
+			return client, err
+		}
+	}
+
+	return gitlab.NewClient()
+}
+
+// warnInsecure warns if the TLS certificate of GitLab is not verified
+func warnInsecure() {
+	if gitlab.InsecureSkipVerify() {
+		fmt.Fprintf(os.Stderr, "age-gitlab-tool-tui: warning: %s\n", gitlab.InsecureWarning)
+	}
+}
@@ -0,0 +1,71 @@
+package cli
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/deathrjj/age-gitlab-tool-tui/config"
+	"github.com/deathrjj/age-gitlab-tool-tui/encryption"
+	"github.com/deathrjj/age-gitlab-tool-tui/trust"
+)
+
+// verifyKeys compares the keys of the users with the ones seen before and
+// prints a warning for every change. New keys have to be confirmed on the
+// terminal before they are encrypted to, unless trustNew is set.
+func verifyKeys(userKeys []encryption.UserKeys, trustNew bool) error {
+	store, err := trust.Load(config.Active())
+	if err != nil {
+		return err
+	}
+	changes, err := store.Verify(userKeys)
+	if err != nil {
+		return err
+	}
+
+	var pending []trust.Change
+	for _, change := range changes {
+		fmt.Fprintf(os.Stderr, "age-gitlab-tool-tui: warning: %s\n", change)
+		if change.NeedsAcceptance() {
+			pending = append(pending, change)
+		}
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+
+	if !trustNew {
+		users := make([]string, len(pending))
+		for i, change := range pending {
+			users[i] = change.User.Key()
+		}
+		confirmed, err := confirm("Encrypt to the new keys and trust them from now on? [y/N] ")
+		if err != nil {
+			return fmt.Errorf("the new keys of %s are not trusted yet, check them and use --trust-new-keys to encrypt to them",
+				strings.Join(users, ", "))
+		}
+		if !confirmed {
+			return fmt.Errorf("the new keys of %s were not trusted", strings.Join(users, ", "))
+		}
+	}
+
+	return store.Accept(pending)
+}
+
+// confirm asks a yes or no question on the controlling terminal
+func confirm(prompt string) (bool, error) {
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return false, fmt.Errorf("cannot ask for confirmation: no terminal available")
+	}
+	defer tty.Close()
+
+	fmt.Fprint(os.Stderr, prompt)
+	answer, err := bufio.NewReader(tty).ReadString('\n')
+	if err != nil {
+		return false, fmt.Errorf("failed to read answer: %w", err)
+	}
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes", nil
+}
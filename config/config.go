@@ -0,0 +1,283 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// DefaultProfileName is the profile values entered at the prompts are saved
+// to if no profile is active
+const DefaultProfileName = "default"
+
+// ProfileEnv is the environment variable holding the name of the active profile
+const ProfileEnv = "AGE_TOOL_PROFILE"
+
+// Profile holds the settings of one GitLab instance. Every setting corresponds
+// to the environment variable of the same name.
+type Profile struct {
+	GitLabURL      string   `toml:"gitlab_url,omitempty"`
+	GitLabToken    string   `toml:"gitlab_token,omitempty"`
+	OAuthClientID  string   `toml:"gitlab_oauth_client_id,omitempty"`
+	PrivateKeyPath string   `toml:"private_key_path,omitempty"`
+	IdentityFiles  []string `toml:"identity_files,omitempty"`
+
+	// How to connect to the instance, see gitlab.NewHTTPClient
+	CAFile             string `toml:"gitlab_ca_file,omitempty"`
+	ClientCert         string `toml:"gitlab_client_cert,omitempty"`
+	ClientKey          string `toml:"gitlab_client_key,omitempty"`
+	Proxy              string `toml:"gitlab_proxy,omitempty"`
+	Timeout            string `toml:"gitlab_timeout,omitempty"`
+	InsecureSkipVerify bool   `toml:"gitlab_insecure_skip_verify,omitempty"`
+
+	// The key policy of the instance, see the policy package
+	PolicyKeyTypes           []string `toml:"policy_key_types,omitempty"`
+	PolicyMinRSABits         int      `toml:"policy_min_rsa_bits,omitempty"`
+	PolicyMaxKeyAge          string   `toml:"policy_max_key_age,omitempty"`
+	PolicyMinKeys            int      `toml:"policy_min_keys,omitempty"`
+	PolicyDeniedFingerprints []string `toml:"policy_denied_fingerprints,omitempty"`
+}
+
+// Config is the contents of the configuration file
+type Config struct {
+	// DefaultProfile is applied when no profile is chosen explicitly
+	DefaultProfile string              `toml:"default_profile,omitempty"`
+	Profiles       map[string]*Profile `toml:"profiles,omitempty"`
+}
+
+// Path returns the location of the configuration file,
+// $XDG_CONFIG_HOME/age-gitlab-tool/config.toml
+func Path() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "age-gitlab-tool", "config.toml"), nil
+}
+
+// Load reads the configuration file. A missing file is an empty configuration.
+func Load() (*Config, error) {
+	path, err := Path()
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &Config{}
+	if _, err := toml.DecodeFile(path, cfg); err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	if cfg.Profiles == nil {
+		cfg.Profiles = make(map[string]*Profile)
+	}
+
+	return cfg, nil
+}
+
+// Save writes the configuration file, readable only by the current user as it
+// may hold tokens
+func (c *Config) Save() error {
+	path, err := Path()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("failed to create config directory: %w", err)
+	}
+
+	// Write to a temporary file first so a failure keeps the old file intact
+	file, err := os.CreateTemp(filepath.Dir(path), ".config.toml.*")
+	if err != nil {
+		return fmt.Errorf("failed to save config: %w", err)
+	}
+	defer os.Remove(file.Name())
+
+	encoder := toml.NewEncoder(file)
+	encoder.Indent = ""
+	err = encoder.Encode(c)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), path)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to save config: %w", err)
+	}
+
+	return nil
+}
+
+// Names returns the names of all profiles, sorted
+func (c *Config) Names() []string {
+	names := make([]string, 0, len(c.Profiles))
+	for name := range c.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Select returns the name of the profile to use: the given name, the one in
+// AGE_TOOL_PROFILE, or the default profile, in that order. explicit tells
+// whether the profile was chosen by the user rather than by default_profile.
+// An empty name means no profile is used.
+func (c *Config) Select(name string) (selected string, explicit bool, err error) {
+	if name == "" {
+		name = os.Getenv(ProfileEnv)
+	}
+	if name != "" {
+		if _, ok := c.Profiles[name]; !ok {
+			return "", false, fmt.Errorf("unknown profile %q", name)
+		}
+		return name, true, nil
+	}
+
+	if c.DefaultProfile != "" {
+		if _, ok := c.Profiles[c.DefaultProfile]; !ok {
+			return "", false, fmt.Errorf("default_profile %q is not defined", c.DefaultProfile)
+		}
+	}
+	return c.DefaultProfile, false, nil
+}
+
+// profileVariables are the environment variables a profile sets
+var profileVariables = []string{"GITLAB_URL", "GITLAB_TOKEN", "GITLAB_OAUTH_CLIENT_ID", "AGE_PRIVATE_KEY_PATH", "AGE_IDENTITY_FILES",
+	"GITLAB_CA_FILE", "GITLAB_CLIENT_CERT", "GITLAB_CLIENT_KEY", "GITLAB_PROXY", "GITLAB_TIMEOUT", "GITLAB_INSECURE_SKIP_VERIFY",
+	"AGE_POLICY_KEY_TYPES", "AGE_POLICY_MIN_RSA_BITS", "AGE_POLICY_MAX_KEY_AGE", "AGE_POLICY_MIN_KEYS", "AGE_POLICY_DENIED_FINGERPRINTS"}
+
+// initialEnvironment holds the values of profileVariables at startup, so that
+// switching profiles does not keep the values of the previous one
+var initialEnvironment = make(map[string]*string)
+
+func init() {
+	for _, name := range profileVariables {
+		if value, ok := os.LookupEnv(name); ok {
+			initialEnvironment[name] = &value
+		}
+	}
+}
+
+// Apply makes name the active profile by setting the environment variables
+// the rest of the tool reads. If override is false, variables that are
+// already set keep their value. Otherwise the values of any previously
+// applied profile are replaced, and only the variables the profile leaves
+// empty keep the value they had at startup.
+func (c *Config) Apply(name string, override bool) error {
+	profile, ok := c.Profiles[name]
+	if !ok {
+		return fmt.Errorf("unknown profile %q", name)
+	}
+
+	if override {
+		for _, variable := range profileVariables {
+			if value := initialEnvironment[variable]; value != nil {
+				os.Setenv(variable, *value)
+			} else {
+				os.Unsetenv(variable)
+			}
+		}
+	}
+
+	values := map[string]string{
+		"GITLAB_URL":             profile.GitLabURL,
+		"GITLAB_TOKEN":           profile.GitLabToken,
+		"GITLAB_OAUTH_CLIENT_ID": profile.OAuthClientID,
+		"AGE_PRIVATE_KEY_PATH":   expandHome(profile.PrivateKeyPath),
+		"AGE_IDENTITY_FILES":     joinPaths(profile.IdentityFiles),
+
+		"GITLAB_CA_FILE":              expandHome(profile.CAFile),
+		"GITLAB_CLIENT_CERT":          expandHome(profile.ClientCert),
+		"GITLAB_CLIENT_KEY":           expandHome(profile.ClientKey),
+		"GITLAB_PROXY":                profile.Proxy,
+		"GITLAB_TIMEOUT":              profile.Timeout,
+		"GITLAB_INSECURE_SKIP_VERIFY": formatBool(profile.InsecureSkipVerify),
+
+		"AGE_POLICY_KEY_TYPES":           strings.Join(profile.PolicyKeyTypes, ","),
+		"AGE_POLICY_MIN_RSA_BITS":        formatCount(profile.PolicyMinRSABits),
+		"AGE_POLICY_MAX_KEY_AGE":         profile.PolicyMaxKeyAge,
+		"AGE_POLICY_MIN_KEYS":            formatCount(profile.PolicyMinKeys),
+		"AGE_POLICY_DENIED_FINGERPRINTS": strings.Join(profile.PolicyDeniedFingerprints, ","),
+	}
+	for variable, value := range values {
+		if value != "" && (override || os.Getenv(variable) == "") {
+			os.Setenv(variable, value)
+		}
+	}
+
+	return os.Setenv(ProfileEnv, name)
+}
+
+// Active returns the name of the active profile, or DefaultProfileName if no
+// profile has been applied
+func Active() string {
+	if name := os.Getenv(ProfileEnv); name != "" {
+		return name
+	}
+	return DefaultProfileName
+}
+
+// Update loads the configuration file, lets update change the named profile,
+// creating it if needed, and saves the file again. A new profile becomes the
+// default profile if there is none yet.
+func Update(name string, update func(profile *Profile)) error {
+	cfg, err := Load()
+	if err != nil {
+		return err
+	}
+
+	profile, ok := cfg.Profiles[name]
+	if !ok {
+		profile = &Profile{}
+		cfg.Profiles[name] = profile
+	}
+	update(profile)
+	if cfg.DefaultProfile == "" {
+		cfg.DefaultProfile = name
+	}
+
+	return cfg.Save()
+}
+
+// expandHome replaces a leading "~/" in path with the home directory
+func expandHome(path string) string {
+	if !strings.HasPrefix(path, "~/") {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+	return filepath.Join(home, path[2:])
+}
+
+// joinPaths joins paths into a list separated like PATH, expanding "~/"
+func joinPaths(paths []string) string {
+	expanded := make([]string, len(paths))
+	for i, path := range paths {
+		expanded[i] = expandHome(path)
+	}
+	return strings.Join(expanded, string(os.PathListSeparator))
+}
+
+// formatBool formats a flag for an environment variable, leaving false empty
+// as it is the default
+func formatBool(b bool) string {
+	if !b {
+		return ""
+	}
+	return "true"
+}
+
+// formatCount formats a number for an environment variable, leaving 0 empty
+// as it is the default
+func formatCount(n int) string {
+	if n == 0 {
+		return ""
+	}
+	return strconv.Itoa(n)
+}
@@ -1,19 +1,25 @@
 package encryption
 
 import (
+	"bufio"
 	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"errors"
 	"fmt"
-	"io/ioutil"
-	"os"
-	"os/exec"
+	"io"
 	"strings"
+	"time"
 
 	"filippo.io/age"
 	"filippo.io/age/agessh"
 	"filippo.io/age/armor"
 	"github.com/atotto/clipboard"
-	"github.com/deathrjj/age-gitlab-tool-tui/gitlab"
 	"github.com/deathrjj/age-gitlab-tool-tui/models"
+	"github.com/deathrjj/age-gitlab-tool-tui/provider"
+	"golang.org/x/crypto/ssh"
 )
 
 // CheckClipboardForAgeFile checks if the clipboard contains an age encrypted file
@@ -31,115 +37,321 @@ func CheckClipboardForAgeFile() (string, bool) {
 	return "", false
 }
 
-// DecryptAgeFile decrypts an age encrypted file using a private key file
-func DecryptAgeFile(encryptedText, privateKeyPath, passphrase string) (string, error) {
-	// Read the private key file
-	keyData, err := ioutil.ReadFile(privateKeyPath)
+// ErrPassphraseRequired is returned when an SSH key is passphrase protected
+// and no passphrase was given
+var ErrPassphraseRequired = errors.New("ssh key is passphrase protected, please provide passphrase")
+
+// ErrIncorrectPassphrase is returned when a private key cannot be decrypted
+// with the given passphrase
+var ErrIncorrectPassphrase = errors.New("incorrect passphrase")
+
+// parseEncryptedIdentity decrypts a passphrase protected SSH private key and
+// returns it as an age identity
+func parseEncryptedIdentity(keyData, passphrase []byte) (age.Identity, error) {
+	rawKey, err := ssh.ParseRawPrivateKeyWithPassphrase(keyData, passphrase)
+	if errors.Is(err, x509.IncorrectPasswordError) {
+		return nil, ErrIncorrectPassphrase
+	}
 	if err != nil {
-		return "", fmt.Errorf("failed to read private key file: %w", err)
+		return nil, err
 	}
 
-	var sshIdentity age.Identity
-	if passphrase == "" {
-		// Try without passphrase
-		sshIdentity, err = agessh.ParseIdentity(keyData)
-		if err != nil && strings.Contains(err.Error(), "passphrase") {
-			return "", fmt.Errorf("ssh key is passphrase protected, please provide passphrase")
-		}
-	} else {
-		// Create a temporary file for SSH key handling
-		sshKeyFile, err := os.CreateTemp("", "ssh-key-*")
+	switch k := rawKey.(type) {
+	case *ed25519.PrivateKey:
+		return agessh.NewEd25519Identity(*k)
+	case ed25519.PrivateKey:
+		return agessh.NewEd25519Identity(k)
+	case *rsa.PrivateKey:
+		return agessh.NewRSAIdentity(k)
+	default:
+		return nil, fmt.Errorf("unsupported SSH key type: %T", rawKey)
+	}
+}
+
+// IsArmored reports whether data starts with an ASCII-armored age file,
+// possibly preceded by whitespace
+func IsArmored(data []byte) bool {
+	return bytes.HasPrefix(bytes.TrimLeft(data, " \t\r\n"), []byte(armor.Header))
+}
+
+// Decrypt reads age ciphertext from src, either ASCII-armored or binary, and
+// writes the plaintext to dst. progress, if not nil, is called with the number
+// of ciphertext bytes read, out of the size of src if it is known.
+func Decrypt(dst io.Writer, src io.Reader, progress ProgressFunc, identities ...age.Identity) error {
+	in := bufio.NewReader(NewProgressReader(src, streamSize(src), progress))
+
+	// Armored files may be preceded by whitespace, which the armor reader skips
+	var ciphertext io.Reader = in
+	start, _ := in.Peek(1024)
+	if IsArmored(start) {
+		ciphertext = armor.NewReader(in)
+	}
+
+	// Decrypt the message using the given identities
+	r, err := age.Decrypt(ciphertext, identities...)
+	if err != nil {
+		return fmt.Errorf("failed to decrypt: %w", err)
+	}
+
+	// Copy the decrypted content
+	if _, err := io.Copy(dst, r); err != nil {
+		return fmt.Errorf("failed to read decrypted content: %w", err)
+	}
+
+	return nil
+}
+
+// Reencrypt decrypts src with the identities and encrypts the plaintext to
+// the recipients at the same time, so the plaintext is never held in memory
+// as a whole. progress is called as for Decrypt.
+func Reencrypt(dst io.Writer, src io.Reader, identities []age.Identity, recipients []age.Recipient, armored bool, progress ProgressFunc) error {
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(Decrypt(pw, src, progress, identities...))
+	}()
+	err := Encrypt(dst, pr, recipients, armored)
+	// Stop decrypting if encryption failed early
+	pr.CloseWithError(err)
+	return err
+}
+
+// EncryptData encrypts plaintext to the given recipients as ASCII-armored text.
+// It keeps the whole ciphertext in memory; use Encrypt for large data.
+func EncryptData(plaintext string, recipients []age.Recipient) (string, error) {
+	var buf bytes.Buffer
+	if err := Encrypt(&buf, strings.NewReader(plaintext), recipients, true); err != nil {
+		return "", err
+	}
+
+	return buf.String(), nil
+}
+
+// UserKeys are the SSH keys and age recipients fetched for a user
+type UserKeys struct {
+	User models.User
+	Keys []models.Key
+}
+
+// FetchKeys fetches the SSH keys and age recipients of every user from the
+// source the user comes from, several users at once. The keys are returned in
+// the order of users. Outstanding requests are cancelled once ctx is done or
+// fetching the keys of a user fails.
+func FetchKeys(ctx context.Context, users []models.User, providers provider.Set) ([]UserKeys, error) {
+	userKeys := make([]UserKeys, len(users))
+	err := provider.Parallel(ctx, len(users), func(ctx context.Context, i int) error {
+		keys, err := providers.FetchKeys(ctx, users[i])
 		if err != nil {
-			return "", fmt.Errorf("failed to create temp file: %w", err)
+			return fmt.Errorf("%s: %w", users[i].Key(), err)
 		}
-		defer os.Remove(sshKeyFile.Name())
-		defer sshKeyFile.Close()
-		
-		// Write the key data to the temp file
-		_, err = sshKeyFile.Write(keyData)
-		if err != nil {
-			return "", fmt.Errorf("failed to write temp key file: %w", err)
+		userKeys[i] = UserKeys{User: users[i], Keys: keys}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return userKeys, nil
+}
+
+// ExcludeKeys returns the keys of every user without the ones excluded. It
+// fails if all keys of a user are excluded, as the user could not decrypt.
+func ExcludeKeys(userKeys []UserKeys, excluded models.KeyExclusionMap) ([]UserKeys, error) {
+	var included []UserKeys
+	for _, uk := range userKeys {
+		var keys []models.Key
+		for _, key := range uk.Keys {
+			if !excluded.Excluded(uk.User, key.Key) {
+				keys = append(keys, key)
+			}
 		}
-		sshKeyFile.Close()
-		
-		// Run the command to decrypt the key with the passphrase
-		decryptCmd := exec.Command("bash", "-c", 
-			fmt.Sprintf("ssh-keygen -p -P '%s' -N '' -f '%s'", passphrase, sshKeyFile.Name()))
-		if err := decryptCmd.Run(); err != nil {
-			return "", fmt.Errorf("failed to decrypt SSH key with passphrase: %w", err)
+		if len(keys) == 0 && len(uk.Keys) > 0 {
+			return nil, fmt.Errorf("all keys of %s are excluded", uk.User.Key())
 		}
-		
-		// Read the decrypted key
-		decryptedKeyData, err := ioutil.ReadFile(sshKeyFile.Name())
-		if err != nil {
-			return "", fmt.Errorf("failed to read decrypted key: %w", err)
+		included = append(included, UserKeys{User: uk.User, Keys: keys})
+	}
+
+	return included, nil
+}
+
+// SkippedKey is a key of a user that is not encrypted to
+type SkippedKey struct {
+	Key models.Key
+	// Type is the short key type, such as "ecdsa"
+	Type string
+	// Reason is "expired", "signing-only", "unsupported" or "invalid"
+	Reason string
+}
+
+// KeyUsage tells which keys of a user are encrypted to and which are skipped
+type KeyUsage struct {
+	User models.User
+	// Used are the types of the keys encrypted to
+	Used    []string
+	Skipped []SkippedKey
+}
+
+// Usable reports whether any key of the user is encrypted to
+func (u KeyUsage) Usable() bool {
+	return len(u.Used) > 0
+}
+
+// String summarizes the keys of the user, such as "bob: 1 ecdsa key
+// skipped, 1 ed25519 used" or "carol: no usable keys"
+func (u KeyUsage) String() string {
+	var skipped []string
+	for _, key := range u.Skipped {
+		switch key.Reason {
+		case "unsupported", "invalid":
+			skipped = append(skipped, key.Type)
+		default:
+			skipped = append(skipped, key.Reason+" "+key.Type)
 		}
-		
-		// Parse the decrypted key
-		sshIdentity, err = agessh.ParseIdentity(decryptedKeyData)
-		if err != nil {
-			return "", fmt.Errorf("failed to parse decrypted SSH key: %w", err)
+	}
+
+	var parts []string
+	for _, count := range countItems(skipped) {
+		if count.n == 1 {
+			parts = append(parts, fmt.Sprintf("1 %s key skipped", count.item))
+		} else {
+			parts = append(parts, fmt.Sprintf("%d %s keys skipped", count.n, count.item))
 		}
 	}
-	
-	if err != nil {
-		return "", fmt.Errorf("failed to parse SSH key: %w", err)
+	if !u.Usable() {
+		if len(parts) == 0 {
+			return u.User.Key() + ": no usable keys"
+		}
+		return fmt.Sprintf("%s: no usable keys (%s)", u.User.Key(), strings.Join(parts, ", "))
+	}
+	for _, count := range countItems(u.Used) {
+		parts = append(parts, fmt.Sprintf("%d %s used", count.n, count.item))
+	}
+	return fmt.Sprintf("%s: %s", u.User.Key(), strings.Join(parts, ", "))
+}
+
+// SkippedKeys returns the key usage of the users whose keys were not all
+// encrypted to, to be shown before encrypting
+func SkippedKeys(usage []KeyUsage) []KeyUsage {
+	var skipped []KeyUsage
+	for _, u := range usage {
+		if len(u.Skipped) > 0 || !u.Usable() {
+			skipped = append(skipped, u)
+		}
+	}
+	return skipped
+}
+
+// ParseUserKeys parses the keys of every user into age recipients. Expired
+// keys, keys only meant for signing and key types age cannot encrypt to,
+// such as ecdsa and security keys, are skipped, as the usage tells.
+func ParseUserKeys(userKeys []UserKeys) ([]age.Recipient, []KeyUsage) {
+	var recipients []age.Recipient
+	var usage []KeyUsage
+	for _, uk := range userKeys {
+		u := KeyUsage{User: uk.User}
+		for _, key := range uk.Keys {
+			rec, keyType, reason := checkKey(key)
+			if reason != "" {
+				u.Skipped = append(u.Skipped, SkippedKey{Key: key, Type: keyType, Reason: reason})
+				continue
+			}
+			recipients = append(recipients, rec)
+			u.Used = append(u.Used, keyType)
+		}
+		usage = append(usage, u)
 	}
 
-	// Decrypt the message using the SSH identity
-	r, err := age.Decrypt(armor.NewReader(strings.NewReader(encryptedText)), sshIdentity)
+	return recipients, usage
+}
+
+// SkipReason returns why a key is not encrypted to, as SkippedKey.Reason, or
+// "" if it is
+func SkipReason(key models.Key) string {
+	_, _, reason := checkKey(key)
+	return reason
+}
+
+// checkKey parses a key into an age recipient and returns its short type, or
+// the reason it cannot be encrypted to
+func checkKey(key models.Key) (recipient age.Recipient, keyType string, reason string) {
+	details, err := DescribeKey(key.Key)
 	if err != nil {
-		return "", fmt.Errorf("failed to decrypt: %w", err)
+		keyType = "empty"
+		if fields := strings.Fields(key.Key); len(fields) > 0 {
+			keyType = fields[0]
+		}
+		return nil, keyType, "invalid"
+	}
+
+	switch {
+	case key.ExpiresAt != nil && key.ExpiresAt.Before(time.Now()):
+		return nil, details.Type, "expired"
+	case key.UsageType == "signing":
+		return nil, details.Type, "signing-only"
 	}
 
-	// Read the decrypted content
-	decrypted, err := ioutil.ReadAll(r)
+	recipient, err = ParseRecipient(strings.TrimSpace(key.Key))
 	if err != nil {
-		return "", fmt.Errorf("failed to read decrypted content: %w", err)
+		return nil, details.Type, "unsupported"
 	}
+	return recipient, details.Type, ""
+}
 
-	return string(decrypted), nil
+// itemCount is how often an item occurs in a list
+type itemCount struct {
+	item string
+	n    int
 }
 
-// EncryptData encrypts plaintext using age with each selected user's key as a recipient
-func EncryptData(plaintext string, selected models.UserSelectionMap, gitlabClient *gitlab.Client) (string, error) {
-	var recipients []age.Recipient
-	
-	for uid := range selected {
-		keys, err := gitlabClient.FetchUserKeys(uid)
-		if err != nil {
-			return "", err
-		}
-		
-		for _, keyStr := range keys {
-			// Use agessh.ParseRecipient to parse an SSH key as an age recipient
-			rec, err := agessh.ParseRecipient(keyStr)
-			if err != nil {
-				return "", fmt.Errorf("failed to parse recipient for user %d: %w", uid, err)
-			}
-			recipients = append(recipients, rec)
+// countItems counts the occurrences of every item, in the order they first
+// occur
+func countItems(items []string) []itemCount {
+	var counts []itemCount
+	index := make(map[string]int)
+	for _, item := range items {
+		if i, ok := index[item]; ok {
+			counts[i].n++
+			continue
 		}
+		index[item] = len(counts)
+		counts = append(counts, itemCount{item: item, n: 1})
 	}
-	
-	var buf bytes.Buffer
-	armorWriter := armor.NewWriter(&buf)
-	
-	w, err := age.Encrypt(armorWriter, recipients...)
+	return counts
+}
+
+// ParseRecipient parses a native age X25519 recipient ("age1...") or an SSH
+// public key as an age recipient
+func ParseRecipient(key string) (age.Recipient, error) {
+	if strings.HasPrefix(key, "age1") {
+		return age.ParseX25519Recipient(key)
+	}
+	return agessh.ParseRecipient(key)
+}
+
+// Encrypt reads plaintext from src and writes it to dst encrypted to the given
+// recipients, ASCII-armored if armored is set
+func Encrypt(dst io.Writer, src io.Reader, recipients []age.Recipient, armored bool) error {
+	out := dst
+	var armorWriter io.WriteCloser
+	if armored {
+		armorWriter = armor.NewWriter(dst)
+		out = armorWriter
+	}
+
+	w, err := age.Encrypt(out, recipients...)
 	if err != nil {
-		return "", err
+		return err
 	}
-	
-	if _, err := w.Write([]byte(plaintext)); err != nil {
-		return "", err
+
+	if _, err := io.Copy(w, src); err != nil {
+		return err
 	}
-	
+
 	if err := w.Close(); err != nil {
-		return "", err
+		return err
 	}
-	
-	if err := armorWriter.Close(); err != nil {
-		return "", err
+
+	if armorWriter != nil {
+		return armorWriter.Close()
 	}
-	
-	return buf.String(), nil
-} 
+	return nil
+}
@@ -0,0 +1,95 @@
+package encryption
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+
+	"filippo.io/age"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// ErrNoAgent is returned by DialAgent when no ssh-agent is available
+var ErrNoAgent = errors.New("no ssh-agent available, SSH_AUTH_SOCK is not set")
+
+// ErrAgentCannotUnwrap is returned when a key held by ssh-agent matches a
+// recipient of the file, but the agent is unable to decrypt it
+var ErrAgentCannotUnwrap = errors.New("ssh-agent cannot decrypt age files")
+
+// AgentIdentity is an age identity backed by the keys held in an ssh-agent,
+// so that the private key files do not need to be read.
+//
+// The agent protocol only supports signing, while ssh-ed25519 stanzas need an
+// X25519 key agreement and ssh-rsa stanzas need an RSA-OAEP decryption. For
+// key types the agent cannot unwrap, Unwrap identifies the matching agent key
+// and returns ErrAgentCannotUnwrap instead, so the user knows which private
+// key file to use.
+type AgentIdentity struct {
+	agent agent.Agent
+	conn  net.Conn
+}
+
+var _ age.Identity = &AgentIdentity{}
+
+// NewAgentIdentity returns an identity using the keys held by the given agent
+func NewAgentIdentity(a agent.Agent) *AgentIdentity {
+	return &AgentIdentity{agent: a}
+}
+
+// DialAgent connects to the ssh-agent listening on SSH_AUTH_SOCK
+func DialAgent() (*AgentIdentity, error) {
+	socket := os.Getenv("SSH_AUTH_SOCK")
+	if socket == "" {
+		return nil, ErrNoAgent
+	}
+
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to ssh-agent: %w", err)
+	}
+
+	return &AgentIdentity{agent: agent.NewClient(conn), conn: conn}, nil
+}
+
+// Close closes the connection to the agent, if DialAgent opened one
+func (i *AgentIdentity) Close() error {
+	if i.conn == nil {
+		return nil
+	}
+	return i.conn.Close()
+}
+
+// Unwrap implements age.Identity
+func (i *AgentIdentity) Unwrap(stanzas []*age.Stanza) ([]byte, error) {
+	keys, err := i.agent.List()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list ssh-agent keys: %w", err)
+	}
+
+	for _, stanza := range stanzas {
+		for _, key := range keys {
+			if !stanzaMatchesKey(stanza, key) {
+				continue
+			}
+
+			// None of the stanza types age supports for SSH keys can be
+			// unwrapped with a signing operation
+			return nil, fmt.Errorf("%w: the file is encrypted to %s key %s (%s) held by the agent, "+
+				"but %s stanzas need the private key itself; pass the private key file instead",
+				ErrAgentCannotUnwrap, key.Type(), ssh.FingerprintSHA256(key), key.Comment, stanza.Type)
+		}
+	}
+
+	return nil, age.ErrIncorrectIdentity
+}
+
+// SSHKeyTag returns the short key tag age stores in ssh-ed25519 and ssh-rsa
+// stanzas to identify the recipient's public key
+func SSHKeyTag(key ssh.PublicKey) string {
+	h := sha256.Sum256(key.Marshal())
+	return base64.RawStdEncoding.EncodeToString(h[:4])
+}
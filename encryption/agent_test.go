@@ -0,0 +1,136 @@
+package encryption
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"filippo.io/age"
+	"filippo.io/age/agessh"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// newSSHRecipient generates an ed25519 key and returns it with the age
+// recipient of its public key
+func newSSHRecipient(t *testing.T) (ed25519.PrivateKey, age.Recipient) {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicKey, err := ssh.NewPublicKey(key.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	recipient, err := agessh.ParseRecipient(string(ssh.MarshalAuthorizedKey(publicKey)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key, recipient
+}
+
+// encryptTo encrypts a short message to the recipients
+func encryptTo(t *testing.T, recipients ...age.Recipient) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := Encrypt(&buf, strings.NewReader("secret"), recipients, false); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestAgentIdentityNamesMatchingKey(t *testing.T) {
+	key, recipient := newSSHRecipient(t)
+	keyring := agent.NewKeyring()
+	if err := keyring.Add(agent.AddedKey{PrivateKey: key, Comment: "alice@laptop"}); err != nil {
+		t.Fatal(err)
+	}
+
+	err := Decrypt(&bytes.Buffer{}, bytes.NewReader(encryptTo(t, recipient)), nil, NewAgentIdentity(keyring))
+	if !errors.Is(err, ErrAgentCannotUnwrap) {
+		t.Fatalf("got %v, want an error matching ErrAgentCannotUnwrap", err)
+	}
+	if !strings.Contains(err.Error(), "alice@laptop") || !strings.Contains(err.Error(), "ssh-ed25519") {
+		t.Errorf("error %q does not name the agent key", err)
+	}
+}
+
+func TestAgentIdentityIgnoresOtherKeys(t *testing.T) {
+	key, _ := newSSHRecipient(t)
+	_, other := newSSHRecipient(t)
+	keyring := agent.NewKeyring()
+	if err := keyring.Add(agent.AddedKey{PrivateKey: key}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Another identity decrypts the file once the agent has no matching key
+	x25519, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ciphertext := encryptTo(t, other, x25519.Recipient())
+
+	var plaintext bytes.Buffer
+	if err := Decrypt(&plaintext, bytes.NewReader(ciphertext), nil, NewAgentIdentity(keyring), x25519); err != nil {
+		t.Fatal(err)
+	}
+	if plaintext.String() != "secret" {
+		t.Errorf("got plaintext %q", plaintext.String())
+	}
+
+	err = Decrypt(&bytes.Buffer{}, bytes.NewReader(encryptTo(t, other)), nil, NewAgentIdentity(keyring))
+	var noMatch *age.NoIdentityMatchError
+	if !errors.As(err, &noMatch) {
+		t.Errorf("got %v, want an *age.NoIdentityMatchError", err)
+	}
+}
+
+func TestDialAgent(t *testing.T) {
+	key, recipient := newSSHRecipient(t)
+	keyring := agent.NewKeyring()
+	if err := keyring.Add(agent.AddedKey{PrivateKey: key}); err != nil {
+		t.Fatal(err)
+	}
+
+	socket := filepath.Join(t.TempDir(), "agent.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				agent.ServeAgent(keyring, conn)
+			}()
+		}
+	}()
+
+	t.Setenv("SSH_AUTH_SOCK", "")
+	if _, err := DialAgent(); !errors.Is(err, ErrNoAgent) {
+		t.Fatalf("got %v without SSH_AUTH_SOCK, want ErrNoAgent", err)
+	}
+
+	t.Setenv("SSH_AUTH_SOCK", socket)
+	identity, err := DialAgent()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer identity.Close()
+
+	err = Decrypt(&bytes.Buffer{}, bytes.NewReader(encryptTo(t, recipient)), nil, identity)
+	if !errors.Is(err, ErrAgentCannotUnwrap) {
+		t.Errorf("got %v, want an error matching ErrAgentCannotUnwrap", err)
+	}
+}
@@ -0,0 +1,85 @@
+package encryption
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"filippo.io/age"
+	"github.com/deathrjj/age-gitlab-tool-tui/archive"
+)
+
+// EncryptPath encrypts the file at path to dst. Directories are encrypted as
+// a gzip compressed tar archive, which is streamed rather than written to disk.
+// progress, if not nil, is called with the number of bytes read; the total is
+// unknown for directories.
+func EncryptPath(dst io.Writer, path string, recipients []age.Recipient, armored bool, progress ProgressFunc) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	if !info.IsDir() {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		return Encrypt(dst, NewProgressReader(f, info.Size(), progress), recipients, armored)
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(archive.Write(pw, path))
+	}()
+	err = Encrypt(dst, NewProgressReader(pr, -1, progress), recipients, armored)
+	// Stop the archive writer if encryption failed early
+	pr.CloseWithError(err)
+	return err
+}
+
+// EncryptedPath returns the default name of the encrypted file for path,
+// which for directories includes the archive extension
+func EncryptedPath(path string) string {
+	path = filepath.Clean(path)
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		return path + archive.Extension + ".age"
+	}
+	return path + ".age"
+}
+
+// EncryptFile encrypts the file or directory at path to the recipients and
+// writes the result to output, as WriteFile does
+func EncryptFile(path, output string, recipients []age.Recipient, armored bool, progress ProgressFunc) error {
+	return WriteFile(output, func(w io.Writer) error {
+		if err := EncryptPath(w, path, recipients, armored, progress); err != nil {
+			return fmt.Errorf("failed to encrypt %s: %w", path, err)
+		}
+		return nil
+	})
+}
+
+// WriteFile calls write with a temporary file next to output, which replaces
+// output only once write succeeded, so output is never left half written
+func WriteFile(output string, write func(w io.Writer) error) error {
+	out, err := os.CreateTemp(filepath.Dir(output), "."+filepath.Base(output)+".*")
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", output, err)
+	}
+
+	err = write(out)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(out.Name())
+		return err
+	}
+
+	if err := os.Rename(out.Name(), output); err != nil {
+		os.Remove(out.Name())
+		return fmt.Errorf("failed to create %s: %w", output, err)
+	}
+	return nil
+}
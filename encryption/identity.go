@@ -0,0 +1,223 @@
+package encryption
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"filippo.io/age"
+	"filippo.io/age/agessh"
+	"golang.org/x/crypto/ssh"
+)
+
+// maxPassphraseAttempts is how often an incorrect passphrase may be entered
+// before decryption is aborted
+const maxPassphraseAttempts = 3
+
+// PassphraseFunc is called to obtain the passphrase of a protected private key.
+// retry is set if the previously entered passphrase was incorrect.
+type PassphraseFunc func(path string, retry bool) (string, error)
+
+// DiscoverIdentityPaths returns the private key files that may be able to
+// decrypt a file: AGE_PRIVATE_KEY_PATH, the age identity files listed in
+// AGE_IDENTITY_FILES and the default SSH keys in ~/.ssh
+func DiscoverIdentityPaths() []string {
+	var candidates []string
+
+	if path := os.Getenv("AGE_PRIVATE_KEY_PATH"); path != "" {
+		candidates = append(candidates, path)
+	}
+
+	for _, path := range filepath.SplitList(os.Getenv("AGE_IDENTITY_FILES")) {
+		if path != "" {
+			candidates = append(candidates, path)
+		}
+	}
+
+	if home, err := os.UserHomeDir(); err == nil {
+		matches, _ := filepath.Glob(filepath.Join(home, ".ssh", "id_*"))
+		for _, path := range matches {
+			if !strings.HasSuffix(path, ".pub") {
+				candidates = append(candidates, path)
+			}
+		}
+	}
+
+	// Drop duplicates and files that do not exist
+	seen := make(map[string]bool)
+	var paths []string
+	for _, path := range candidates {
+		abs, err := filepath.Abs(path)
+		if err != nil || seen[abs] {
+			continue
+		}
+		seen[abs] = true
+		if info, err := os.Stat(path); err == nil && info.Mode().IsRegular() {
+			paths = append(paths, path)
+		}
+	}
+
+	return paths
+}
+
+// LoadIdentityFiles loads the identities from the given SSH private key and
+// age identity files. Unencrypted identities are returned first, so they are
+// tried before any passphrase is asked for. The passphrase of a protected SSH
+// key is only requested once its public key matches a recipient of the file
+// being decrypted. If skipInvalid is set, files that cannot be used are
+// ignored instead of returning an error.
+func LoadIdentityFiles(paths []string, passphrase PassphraseFunc, skipInvalid bool) ([]age.Identity, error) {
+	var identities, protected []age.Identity
+
+	for _, path := range paths {
+		loaded, err := loadIdentityFile(path, passphrase)
+		if err != nil {
+			if skipInvalid {
+				continue
+			}
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+
+		for _, identity := range loaded {
+			if _, ok := identity.(*protectedKeyIdentity); ok {
+				protected = append(protected, identity)
+			} else {
+				identities = append(identities, identity)
+			}
+		}
+	}
+
+	return append(identities, protected...), nil
+}
+
+// IdentityRecipient returns the recipient that the first identity in the SSH
+// private key or age identity file at path can decrypt. No passphrase is
+// needed, as protected SSH keys store their public key unencrypted or next to
+// them in a .pub file.
+func IdentityRecipient(path string) (age.Recipient, error) {
+	identities, err := loadIdentityFile(path, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	switch identity := identities[0].(type) {
+	case *age.X25519Identity:
+		return identity.Recipient(), nil
+	case *agessh.Ed25519Identity:
+		return identity.Recipient(), nil
+	case *agessh.RSAIdentity:
+		return identity.Recipient(), nil
+	case *protectedKeyIdentity:
+		if identity.publicKey == nil {
+			return nil, fmt.Errorf("the public key of %s is unknown, add it as %s.pub", path, path)
+		}
+		return agessh.ParseRecipient(strings.TrimSpace(string(ssh.MarshalAuthorizedKey(identity.publicKey))))
+	}
+	return nil, fmt.Errorf("unsupported identity in %s", path)
+}
+
+// loadIdentityFile parses a single SSH private key or age identity file
+func loadIdentityFile(path string, passphrase PassphraseFunc) ([]age.Identity, error) {
+	keyData, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read private key file: %w", err)
+	}
+
+	if bytes.Contains(keyData, []byte("AGE-SECRET-KEY-1")) {
+		identities, err := age.ParseIdentities(bytes.NewReader(keyData))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse age identity file: %w", err)
+		}
+		return identities, nil
+	}
+
+	identity, err := agessh.ParseIdentity(keyData)
+	if err == nil {
+		return []age.Identity{identity}, nil
+	}
+
+	var missingErr *ssh.PassphraseMissingError
+	if !errors.As(err, &missingErr) {
+		return nil, fmt.Errorf("failed to parse SSH key: %w", err)
+	}
+
+	// Keys in the legacy PEM format do not include their public key, which
+	// is then looked for next to the private key
+	publicKey := missingErr.PublicKey
+	if publicKey == nil {
+		if pubData, err := os.ReadFile(path + ".pub"); err == nil {
+			publicKey, _, _, _, _ = ssh.ParseAuthorizedKey(pubData)
+		}
+	}
+
+	return []age.Identity{&protectedKeyIdentity{
+		path:       path,
+		keyData:    keyData,
+		publicKey:  publicKey,
+		passphrase: passphrase,
+	}}, nil
+}
+
+// protectedKeyIdentity is a passphrase protected SSH key that is only
+// decrypted once it is needed
+type protectedKeyIdentity struct {
+	path       string
+	keyData    []byte
+	publicKey  ssh.PublicKey
+	passphrase PassphraseFunc
+	identity   age.Identity
+}
+
+// Unwrap implements age.Identity
+func (i *protectedKeyIdentity) Unwrap(stanzas []*age.Stanza) ([]byte, error) {
+	// Without a public key there is no way to tell whether the key matches,
+	// so the passphrase is asked for anyway
+	if i.publicKey != nil && !matchesAnyStanza(i.publicKey, stanzas) {
+		return nil, age.ErrIncorrectIdentity
+	}
+
+	if i.identity == nil {
+		if i.passphrase == nil {
+			return nil, fmt.Errorf("%s: %w", i.path, ErrPassphraseRequired)
+		}
+
+		for attempt := 1; ; attempt++ {
+			passphrase, err := i.passphrase(i.path, attempt > 1)
+			if err != nil {
+				return nil, err
+			}
+
+			identity, err := parseEncryptedIdentity(i.keyData, []byte(passphrase))
+			if errors.Is(err, ErrIncorrectPassphrase) && attempt < maxPassphraseAttempts {
+				continue
+			}
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", i.path, err)
+			}
+
+			i.identity = identity
+			break
+		}
+	}
+
+	return i.identity.Unwrap(stanzas)
+}
+
+// matchesAnyStanza reports whether any of the stanzas is addressed to the SSH public key
+func matchesAnyStanza(key ssh.PublicKey, stanzas []*age.Stanza) bool {
+	for _, stanza := range stanzas {
+		if stanzaMatchesKey(stanza, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// stanzaMatchesKey reports whether an ssh-ed25519 or ssh-rsa stanza is
+// addressed to the SSH public key
+func stanzaMatchesKey(stanza *age.Stanza, key ssh.PublicKey) bool {
+	return len(stanza.Args) > 0 && stanza.Type == key.Type() && stanza.Args[0] == SSHKeyTag(key)
+}
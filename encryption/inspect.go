@@ -0,0 +1,260 @@
+package encryption
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"filippo.io/age"
+	"github.com/deathrjj/age-gitlab-tool-tui/models"
+	"github.com/deathrjj/age-gitlab-tool-tui/provider"
+	"golang.org/x/crypto/ssh"
+)
+
+// FileRecipient is a recipient stanza found in the header of an age file
+type FileRecipient struct {
+	// Type is the stanza type, such as "ssh-ed25519", "ssh-rsa" or "X25519"
+	Type string
+	// Tag identifies the public key of ssh-ed25519 and ssh-rsa stanzas
+	Tag string
+
+	// Username, Source, KeyType and Fingerprint are set once the recipient
+	// has been matched to the SSH key of a user
+	Username    string
+	Source      string
+	KeyType     string
+	Fingerprint string
+}
+
+// Known reports whether the recipient was matched to a user
+func (r FileRecipient) Known() bool {
+	return r.Username != ""
+}
+
+// headerRecorder is an age identity that never matches, but remembers the
+// stanzas it was offered
+type headerRecorder struct {
+	stanzas []*age.Stanza
+}
+
+// Unwrap implements age.Identity
+func (h *headerRecorder) Unwrap(stanzas []*age.Stanza) ([]byte, error) {
+	h.stanzas = stanzas
+	return nil, age.ErrIncorrectIdentity
+}
+
+// ReadRecipients returns the recipients listed in the header of an age file,
+// either ASCII-armored or binary, without needing any private key
+func ReadRecipients(src io.Reader) ([]FileRecipient, error) {
+	recorder := &headerRecorder{}
+	err := Decrypt(io.Discard, src, nil, recorder)
+
+	var noMatch *age.NoIdentityMatchError
+	if !errors.As(err, &noMatch) {
+		if err == nil {
+			err = errors.New("unexpected successful decryption")
+		}
+		return nil, fmt.Errorf("failed to read age header: %w", err)
+	}
+
+	var recipients []FileRecipient
+	for _, stanza := range recorder.stanzas {
+		// Grease stanzas are random noise some implementations add
+		if strings.HasSuffix(stanza.Type, "-grease") {
+			continue
+		}
+
+		recipient := FileRecipient{Type: stanza.Type}
+		if (stanza.Type == "ssh-ed25519" || stanza.Type == "ssh-rsa") && len(stanza.Args) > 0 {
+			recipient.Tag = stanza.Args[0]
+		}
+		recipients = append(recipients, recipient)
+	}
+
+	return recipients, nil
+}
+
+// IdentifyRecipients matches the SSH recipients of an age file against the
+// SSH keys of the given users, filling in who each recipient belongs to. The
+// keys of several users are fetched at once, until every recipient is found.
+// Users whose keys are not found or not visible, such as blocked accounts,
+// are skipped, while any other error, such as a rejected token or an
+// exhausted rate limit, is returned. Native age and passphrase recipients
+// cannot be identified.
+func IdentifyRecipients(ctx context.Context, recipients []FileRecipient, users []models.User, providers provider.Set) error {
+	remaining := 0
+	for _, recipient := range recipients {
+		if recipient.Tag != "" {
+			remaining++
+		}
+	}
+	if remaining == 0 {
+		return nil
+	}
+
+	ctx, stop := context.WithCancel(ctx)
+	defer stop()
+
+	var mu sync.Mutex
+	err := provider.Parallel(ctx, len(users), func(ctx context.Context, i int) error {
+		user := users[i]
+		keys, err := providers.FetchSSHKeys(ctx, user)
+		if errors.Is(err, provider.ErrNotFound) || errors.Is(err, provider.ErrForbidden) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to fetch the keys of %s: %w", user.Key(), err)
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		for _, key := range keys {
+			publicKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(key.Key))
+			if err != nil {
+				continue
+			}
+
+			tag := SSHKeyTag(publicKey)
+			for i := range recipients {
+				recipient := &recipients[i]
+				if recipient.Known() || recipient.Type != publicKey.Type() || recipient.Tag != tag {
+					continue
+				}
+				recipient.Username = user.Username
+				recipient.Source = user.Source
+				recipient.KeyType = strings.TrimPrefix(publicKey.Type(), "ssh-")
+				recipient.Fingerprint = ssh.FingerprintSHA256(publicKey)
+				remaining--
+			}
+		}
+
+		// Stop early once every recipient has been found
+		if remaining == 0 {
+			stop()
+		}
+		return nil
+	})
+
+	mu.Lock()
+	defer mu.Unlock()
+	if err != nil && remaining > 0 {
+		return err
+	}
+	return nil
+}
+
+// FormatRecipients summarizes the recipients of an age file on a single line,
+// for example "alice (ed25519 SHA256:...), bob (rsa SHA256:...), 1 unknown recipient"
+func FormatRecipients(recipients []FileRecipient) string {
+	var parts []string
+	unknown := 0
+	for _, recipient := range recipients {
+		if !recipient.Known() {
+			unknown++
+			continue
+		}
+		parts = append(parts, DescribeRecipient(recipient))
+	}
+
+	switch unknown {
+	case 0:
+	case 1:
+		parts = append(parts, "1 unknown recipient")
+	default:
+		parts = append(parts, fmt.Sprintf("%d unknown recipients", unknown))
+	}
+
+	if len(parts) == 0 {
+		return "no recipients"
+	}
+	return strings.Join(parts, ", ")
+}
+
+// DescribeRecipient returns a single line describing a recipient of an age file
+func DescribeRecipient(recipient FileRecipient) string {
+	switch {
+	case recipient.Known() && recipient.Source != models.GitLabSource:
+		return fmt.Sprintf("%s from %s (%s %s)", recipient.Username, recipient.Source, recipient.KeyType, recipient.Fingerprint)
+	case recipient.Known():
+		return fmt.Sprintf("%s (%s %s)", recipient.Username, recipient.KeyType, recipient.Fingerprint)
+	case recipient.Tag != "":
+		return fmt.Sprintf("unknown %s key (tag %s)", recipient.Type, recipient.Tag)
+	case recipient.Type == "X25519":
+		return "native age recipient (cannot be identified)"
+	case recipient.Type == "scrypt":
+		return "passphrase"
+	default:
+		return fmt.Sprintf("unknown %s recipient", recipient.Type)
+	}
+}
+
+// KeyDetails describes a public key of a user
+type KeyDetails struct {
+	// Type is the short key type, such as "ed25519", "rsa", "ecdsa" or
+	// "sk-ed25519", or "X25519" for native age recipients
+	Type string
+	// Bits is the key size, or 0 if unknown
+	Bits int
+	// Fingerprint is the SHA256 fingerprint of SSH keys, and the recipient
+	// itself for native age recipients
+	Fingerprint string
+}
+
+// DescribeKey returns the type, size and fingerprint of an SSH public key or
+// native age recipient
+func DescribeKey(key string) (KeyDetails, error) {
+	key = strings.TrimSpace(key)
+	if strings.HasPrefix(key, "age1") {
+		if _, err := age.ParseX25519Recipient(key); err != nil {
+			return KeyDetails{}, err
+		}
+		return KeyDetails{Type: "X25519", Bits: 256, Fingerprint: key}, nil
+	}
+
+	publicKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(key))
+	if err != nil {
+		return KeyDetails{}, err
+	}
+
+	details := KeyDetails{
+		Type:        shortKeyType(publicKey.Type()),
+		Fingerprint: ssh.FingerprintSHA256(publicKey),
+	}
+	if cryptoKey, ok := publicKey.(ssh.CryptoPublicKey); ok {
+		switch k := cryptoKey.CryptoPublicKey().(type) {
+		case *rsa.PublicKey:
+			details.Bits = k.N.BitLen()
+		case *ecdsa.PublicKey:
+			details.Bits = k.Curve.Params().BitSize
+		case ed25519.PublicKey:
+			details.Bits = 256
+		}
+	}
+
+	return details, nil
+}
+
+// shortKeyTypes are the names of SSH key types shown to users
+var shortKeyTypes = map[string]string{
+	ssh.KeyAlgoDSA:        "dsa",
+	ssh.KeyAlgoECDSA256:   "ecdsa",
+	ssh.KeyAlgoECDSA384:   "ecdsa",
+	ssh.KeyAlgoECDSA521:   "ecdsa",
+	ssh.KeyAlgoSKECDSA256: "sk-ecdsa",
+	ssh.KeyAlgoSKED25519:  "sk-ed25519",
+}
+
+// shortKeyType returns the name of an SSH key type shown to users, such as
+// "ed25519" for "ssh-ed25519" or "ecdsa" for "ecdsa-sha2-nistp256"
+func shortKeyType(keyType string) string {
+	if name, ok := shortKeyTypes[keyType]; ok {
+		return name
+	}
+	return strings.TrimPrefix(keyType, "ssh-")
+}
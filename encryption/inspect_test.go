@@ -0,0 +1,109 @@
+package encryption
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"filippo.io/age"
+	"github.com/deathrjj/age-gitlab-tool-tui/models"
+	"github.com/deathrjj/age-gitlab-tool-tui/provider"
+	"golang.org/x/crypto/ssh"
+)
+
+// fakeProvider serves the SSH keys of its users, or an error for the users
+// in errs. FetchKeys fails, as only the SSH keys are needed.
+type fakeProvider struct {
+	keys map[string][]models.Key
+	errs map[string]error
+}
+
+func (p *fakeProvider) Name() string { return "fake" }
+
+func (p *fakeProvider) ListUsers(ctx context.Context) ([]models.User, error) { return nil, nil }
+
+func (p *fakeProvider) FetchKeys(ctx context.Context, user models.User) ([]models.Key, error) {
+	return nil, errors.New("FetchKeys called instead of FetchSSHKeys")
+}
+
+func (p *fakeProvider) FetchSSHKeys(ctx context.Context, user models.User) ([]models.Key, error) {
+	if err := p.errs[user.Username]; err != nil {
+		return nil, err
+	}
+	return p.keys[user.Username], nil
+}
+
+// recipientsOf encrypts to a new SSH key and returns the recipients of the
+// file, along with the key as a models.Key
+func recipientsOf(t *testing.T) ([]FileRecipient, models.Key) {
+	t.Helper()
+	key, recipient := newSSHRecipient(t)
+	publicKey, err := ssh.NewPublicKey(key.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recipients, err := ReadRecipients(bytes.NewReader(encryptTo(t, recipient)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return recipients, models.Key{Key: string(ssh.MarshalAuthorizedKey(publicKey))}
+}
+
+func TestIdentifyRecipientsSkipsMissingUsers(t *testing.T) {
+	recipients, key := recipientsOf(t)
+	users := []models.User{{Username: "blocked", Source: "fake"}, {Username: "hidden", Source: "fake"}, {Username: "alice", Source: "fake"}}
+	p := &fakeProvider{
+		keys: map[string][]models.Key{"alice": {key}},
+		errs: map[string]error{
+			"blocked": &provider.APIError{StatusCode: 404, Status: "404 Not Found"},
+			"hidden":  &provider.APIError{StatusCode: 403, Status: "403 Forbidden"},
+		},
+	}
+
+	if err := IdentifyRecipients(context.Background(), recipients, users, provider.Set{p}); err != nil {
+		t.Fatal(err)
+	}
+	if recipients[0].Username != "alice" || recipients[0].Source != "fake" || recipients[0].KeyType != "ed25519" {
+		t.Errorf("got recipient %+v, want alice's ed25519 key", recipients[0])
+	}
+}
+
+func TestIdentifyRecipientsReturnsLookupErrors(t *testing.T) {
+	recipients, _ := recipientsOf(t)
+	for _, lookupErr := range []error{
+		&provider.APIError{StatusCode: 401, Status: "401 Unauthorized"},
+		&provider.APIError{StatusCode: 429, Status: "429 Too Many Requests", RateLimited: true},
+		errors.New("connection refused"),
+	} {
+		t.Run(lookupErr.Error(), func(t *testing.T) {
+			users := []models.User{{Username: "alice", Source: "fake"}}
+			p := &fakeProvider{errs: map[string]error{"alice": lookupErr}}
+
+			err := IdentifyRecipients(context.Background(), recipients, users, provider.Set{p})
+			if !errors.Is(err, lookupErr) {
+				t.Errorf("got %v, want an error wrapping %v", err, lookupErr)
+			}
+		})
+	}
+}
+
+func TestIdentifyRecipientsIgnoresNativeRecipients(t *testing.T) {
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	recipients, err := ReadRecipients(bytes.NewReader(encryptTo(t, identity.Recipient())))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// No keys are fetched without SSH recipients
+	p := &fakeProvider{errs: map[string]error{"alice": fmt.Errorf("unexpected request")}}
+	err = IdentifyRecipients(context.Background(), recipients, []models.User{{Username: "alice", Source: "fake"}}, provider.Set{p})
+	if err != nil || recipients[0].Known() {
+		t.Errorf("got %v and %+v, want an unknown recipient", err, recipients[0])
+	}
+}
@@ -0,0 +1,83 @@
+package encryption
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// ProgressFunc is called while a stream is processed with the number of
+// bytes done so far and the total, which is -1 if unknown
+type ProgressFunc func(done, total int64)
+
+// progressInterval limits how often a ProgressReader reports progress, so a
+// UI is not redrawn for every block of a large file
+const progressInterval = 100 * time.Millisecond
+
+// ProgressReader is an io.Reader that reports how much of the underlying
+// reader has been read. Progress is reported at most every progressInterval,
+// and always once the end of the stream is reached.
+type ProgressReader struct {
+	r        io.Reader
+	done     int64
+	total    int64
+	progress ProgressFunc
+	last     time.Time
+}
+
+// NewProgressReader returns a reader reporting its progress through r, out
+// of total bytes, to progress. If progress is nil, r is returned unchanged.
+func NewProgressReader(r io.Reader, total int64, progress ProgressFunc) io.Reader {
+	if progress == nil {
+		return r
+	}
+	return &ProgressReader{r: r, total: total, progress: progress}
+}
+
+// streamSize returns the number of bytes left to read from r if it is a
+// regular file or an in-memory reader, or -1
+func streamSize(r io.Reader) int64 {
+	switch r := r.(type) {
+	case interface{ Len() int }:
+		return int64(r.Len())
+	case *os.File:
+		info, err := r.Stat()
+		if err != nil || !info.Mode().IsRegular() {
+			return -1
+		}
+		offset, err := r.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return -1
+		}
+		return info.Size() - offset
+	}
+	return -1
+}
+
+// FormatSize formats a number of bytes for display, such as "1.5 MB"
+func FormatSize(bytes int64) string {
+	const unit = 1000
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+	div, exp := int64(unit), 0
+	for n := bytes / unit; n >= unit && exp < 4; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(bytes)/float64(div), "kMGTP"[exp])
+}
+
+// Read implements io.Reader
+func (p *ProgressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	p.done += int64(n)
+
+	if err == io.EOF || time.Since(p.last) >= progressInterval {
+		p.last = time.Now()
+		p.progress(p.done, p.total)
+	}
+
+	return n, err
+}
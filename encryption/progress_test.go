@@ -0,0 +1,39 @@
+package encryption
+
+import (
+	"bytes"
+	"crypto/rand"
+	"testing"
+
+	"filippo.io/age"
+)
+
+func TestDecryptReportsProgress(t *testing.T) {
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	plaintext := make([]byte, 1<<20)
+	rand.Read(plaintext)
+	var ciphertext bytes.Buffer
+	if err := Encrypt(&ciphertext, bytes.NewReader(plaintext), []age.Recipient{identity.Recipient()}, true); err != nil {
+		t.Fatal(err)
+	}
+	size := int64(ciphertext.Len())
+
+	var done, total int64
+	var decrypted bytes.Buffer
+	err = Decrypt(&decrypted, bytes.NewReader(ciphertext.Bytes()), func(d, t int64) {
+		done, total = d, t
+	}, identity)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(decrypted.Bytes(), plaintext) {
+		t.Error("decrypted content differs")
+	}
+	if done != size || total != size {
+		t.Errorf("last progress %d of %d, want %d of %d", done, total, size, size)
+	}
+}
@@ -0,0 +1,229 @@
+package gitea
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/deathrjj/age-gitlab-tool-tui/cache"
+	"github.com/deathrjj/age-gitlab-tool-tui/models"
+	"github.com/deathrjj/age-gitlab-tool-tui/provider"
+)
+
+// Source is the provider name of users fetched from Gitea or Forgejo
+const Source = "gitea"
+
+// Client handles Gitea and Forgejo API interactions
+type Client struct {
+	BaseURL string
+	Token   string
+	Org     string
+	Teams   []string
+	Users   []string
+	client  *http.Client
+	cache   *cache.Store
+	limiter provider.RateLimiter
+}
+
+// Configured reports whether a Gitea or Forgejo instance is configured by GITEA_URL
+func Configured() bool {
+	return os.Getenv("GITEA_URL") != ""
+}
+
+// NewClient creates a new Gitea client from the GITEA_* environment variables.
+// GITEA_TOKEN is optional, but most instances only list users to signed in
+// users. How to connect is configured as for GitLab, see
+// provider.NewHTTPClient.
+func NewClient() (*Client, error) {
+	baseURL := strings.TrimSuffix(os.Getenv("GITEA_URL"), "/")
+	if baseURL == "" {
+		return nil, fmt.Errorf("GITEA_URL not set")
+	}
+
+	org := os.Getenv("GITEA_ORG")
+	teams := provider.SplitList(os.Getenv("GITEA_TEAMS"))
+	if org == "" && len(teams) > 0 {
+		return nil, fmt.Errorf("GITEA_TEAMS needs GITEA_ORG to be set")
+	}
+
+	token := os.Getenv("GITEA_TOKEN")
+	store, err := cache.Default(token)
+	if err != nil {
+		return nil, err
+	}
+	httpClient, err := provider.NewHTTPClient("GITEA")
+	if err != nil {
+		return nil, err
+	}
+
+	return &Client{
+		BaseURL: baseURL,
+		Token:   token,
+		Org:     org,
+		Teams:   teams,
+		Users:   provider.SplitList(os.Getenv("GITEA_USERS")),
+		client:  httpClient,
+		cache:   store,
+	}, nil
+}
+
+// user is a user as returned by the Gitea API
+type user struct {
+	ID    int    `json:"id"`
+	Login string `json:"login"`
+}
+
+// team is an organization team as returned by the Gitea API
+type team struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+// FetchUsers retrieves the members of the configured teams or organization
+// together with the configured users. Without either, all users of the
+// instance are listed.
+func (c *Client) FetchUsers(ctx context.Context) ([]models.User, error) {
+	var members []user
+	switch {
+	case len(c.Teams) > 0:
+		teamMembers := make([][]user, len(c.Teams))
+		err := provider.Parallel(ctx, len(c.Teams), func(ctx context.Context, i int) error {
+			id, err := c.findTeam(ctx, c.Teams[i])
+			if err != nil {
+				return err
+			}
+			teamMembers[i], err = fetchAllPages[user](ctx, c, fmt.Sprintf("teams/%d/members", id))
+			if err != nil {
+				return fmt.Errorf("failed to fetch members of team %s: %w", c.Teams[i], err)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range teamMembers {
+			members = append(members, m...)
+		}
+	case c.Org != "":
+		orgMembers, err := fetchAllPages[user](ctx, c, "orgs/"+url.PathEscape(c.Org)+"/members")
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch members of organization %s: %w", c.Org, err)
+		}
+		members = orgMembers
+	case len(c.Users) == 0:
+		all, err := fetchAllSearchPages[user](ctx, c, "users/search")
+		if err != nil {
+			return nil, err
+		}
+		members = all
+	}
+	for _, login := range c.Users {
+		members = append(members, user{Login: login})
+	}
+
+	seen := make(map[string]bool)
+	var users []models.User
+	for _, m := range members {
+		if seen[strings.ToLower(m.Login)] {
+			continue
+		}
+		seen[strings.ToLower(m.Login)] = true
+		users = append(users, models.User{ID: m.ID, Username: m.Login, Source: Source})
+	}
+
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].Username < users[j].Username
+	})
+
+	return users, nil
+}
+
+// findTeam returns the ID of the team of the configured organization with the
+// given name
+func (c *Client) findTeam(ctx context.Context, name string) (int, error) {
+	teams, err := fetchAllSearchPages[team](ctx, c, fmt.Sprintf("orgs/%s/teams/search?q=%s",
+		url.PathEscape(c.Org), url.QueryEscape(name)))
+	if err != nil {
+		return 0, fmt.Errorf("failed to find team %s: %w", name, err)
+	}
+	for _, t := range teams {
+		if strings.EqualFold(t.Name, name) {
+			return t.ID, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown team %s in organization %s", name, c.Org)
+}
+
+// FetchUserKeys retrieves the SSH keys of a user, with their titles and
+// creation dates
+func (c *Client) FetchUserKeys(ctx context.Context, login string) ([]models.Key, error) {
+	return fetchAllPages[models.Key](ctx, c, "users/"+url.PathEscape(login)+"/keys")
+}
+
+// perPage is the page size requested from paginated endpoints, which is the
+// largest one Gitea allows by default
+const perPage = 50
+
+// fetchAllPages retrieves every page of a paginated API endpoint, given
+// relative to /api/v1, and concatenates the results. After the first page,
+// as many pages as provider.Concurrency allows are fetched at once.
+func fetchAllPages[T any](ctx context.Context, c *Client, path string) ([]T, error) {
+	return provider.FetchPages(ctx, perPage, func(ctx context.Context, page int) ([]T, error) {
+		var items []T
+		if err := c.get(ctx, pageURL(c, path, page), &items); err != nil {
+			return nil, err
+		}
+		return items, nil
+	})
+}
+
+// fetchAllSearchPages is fetchAllPages for search endpoints, which wrap their
+// results in a data field
+func fetchAllSearchPages[T any](ctx context.Context, c *Client, path string) ([]T, error) {
+	return provider.FetchPages(ctx, perPage, func(ctx context.Context, page int) ([]T, error) {
+		var result struct {
+			Data []T `json:"data"`
+		}
+		if err := c.get(ctx, pageURL(c, path, page), &result); err != nil {
+			return nil, err
+		}
+		return result.Data, nil
+	})
+}
+
+// pageURL returns the URL of a page of an API endpoint given relative to /api/v1
+func pageURL(c *Client, path string, page int) string {
+	separator := "?"
+	if strings.Contains(path, "?") {
+		separator = "&"
+	}
+	return fmt.Sprintf("%s/api/v1/%s%spage=%d&limit=%d", c.BaseURL, path, separator, page, perPage)
+}
+
+// get performs a GET request, authenticated if a token is set, or answers it
+// from the cache, and decodes the JSON response into v. Error responses are
+// returned as a *provider.APIError.
+func (c *Client) get(ctx context.Context, endpoint string, v interface{}) error {
+	resp, err := c.cache.Get(ctx, endpoint, func(req *http.Request) (*http.Response, error) {
+		if c.Token != "" {
+			req.Header.Add("Authorization", "token "+c.Token)
+		}
+		return c.limiter.Do(c.client, req)
+	})
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return provider.NewAPIError(Source, endpoint, resp.StatusCode, resp.Status, resp.Header, resp.Body)
+	}
+
+	if err := json.Unmarshal(resp.Body, v); err != nil {
+		return fmt.Errorf("GET %s: invalid response: %w", endpoint, err)
+	}
+	return nil
+}
@@ -0,0 +1,163 @@
+package gitea
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/deathrjj/age-gitlab-tool-tui/cache"
+	"github.com/deathrjj/age-gitlab-tool-tui/models"
+	"github.com/deathrjj/age-gitlab-tool-tui/provider"
+)
+
+// newTestClient starts a fake Gitea with handler and returns a client for it
+// configured by env
+func newTestClient(t *testing.T, handler http.Handler, env map[string]string) *Client {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	t.Setenv("GITEA_URL", server.URL)
+	for _, name := range []string{"GITEA_ORG", "GITEA_TEAMS", "GITEA_USERS", "GITEA_TOKEN"} {
+		t.Setenv(name, env[name])
+	}
+
+	client, err := NewClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Every test starts with an empty cache of its own
+	client.cache = cache.New(t.TempDir(), cache.DefaultTTL)
+	return client
+}
+
+// pageOf returns the users named user000, user001 and so on of the page
+// requested, out of count
+func pageOf(r *http.Request, count int) []user {
+	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	users := []user{}
+	for i := (page - 1) * limit; i < page*limit && i < count; i++ {
+		users = append(users, user{ID: i + 1, Login: fmt.Sprintf("user%03d", i)})
+	}
+	return users
+}
+
+func TestFetchUsersSearchesAllPages(t *testing.T) {
+	var mu sync.Mutex
+	requested := make(map[string]int)
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/users/search", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "token secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		mu.Lock()
+		requested[r.URL.Query().Get("page")]++
+		mu.Unlock()
+		json.NewEncoder(w).Encode(map[string]interface{}{"ok": true, "data": pageOf(r, 120)})
+	})
+	client := newTestClient(t, mux, map[string]string{"GITEA_TOKEN": "secret"})
+
+	users, err := client.FetchUsers(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(users) != 120 {
+		t.Fatalf("got %d users, want 120", len(users))
+	}
+	if users[0].Username != "user000" || users[119].Username != "user119" || users[0].Source != Source {
+		t.Errorf("got users %+v ... %+v", users[0], users[119])
+	}
+	for _, page := range []string{"1", "2", "3"} {
+		if requested[page] != 1 {
+			t.Errorf("page %s requested %d times, want once", page, requested[page])
+		}
+	}
+}
+
+func TestFetchUsersOfTeams(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/orgs/acme/teams/search", func(w http.ResponseWriter, r *http.Request) {
+		teams := map[string][]team{
+			"Owners": {{ID: 5, Name: "Owners"}},
+			"ops":    {{ID: 6, Name: "ops-oncall"}, {ID: 7, Name: "Ops"}},
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"ok": true, "data": teams[r.URL.Query().Get("q")]})
+	})
+	mux.HandleFunc("GET /api/v1/teams/5/members", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode([]user{{ID: 1, Login: "alice"}})
+	})
+	mux.HandleFunc("GET /api/v1/teams/7/members", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode([]user{{ID: 1, Login: "alice"}, {ID: 2, Login: "bob"}})
+	})
+	client := newTestClient(t, mux, map[string]string{"GITEA_ORG": "acme", "GITEA_TEAMS": "Owners,ops", "GITEA_USERS": "carol"})
+
+	users, err := client.FetchUsers(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var logins []string
+	for _, user := range users {
+		logins = append(logins, user.Username)
+	}
+	if fmt.Sprint(logins) != "[alice bob carol]" {
+		t.Errorf("got users %v, want [alice bob carol]", logins)
+	}
+}
+
+func TestFetchUsersOfUnknownTeam(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/orgs/acme/teams/search", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"ok": true, "data": []team{}})
+	})
+	client := newTestClient(t, mux, map[string]string{"GITEA_ORG": "acme", "GITEA_TEAMS": "nobody"})
+
+	if _, err := client.FetchUsers(context.Background()); err == nil {
+		t.Fatal("got no error for an unknown team")
+	}
+}
+
+func TestFetchUserKeys(t *testing.T) {
+	attempts := 0
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/users/alice/keys", func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts == 1 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		json.NewEncoder(w).Encode([]models.Key{{ID: 1, Title: "laptop", Key: "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIA"}})
+	})
+	mux.HandleFunc("GET /api/v1/users/nobody/keys", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"message": "user does not exist [uid: 0, name: nobody]"})
+	})
+	client := newTestClient(t, mux, map[string]string{"GITEA_USERS": "alice"})
+
+	// The rate limited request is retried
+	keys, err := client.FetchUserKeys(context.Background(), "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 || keys[0].Title != "laptop" || attempts != 2 {
+		t.Errorf("got keys %+v after %d attempts", keys, attempts)
+	}
+
+	_, err = client.FetchUserKeys(context.Background(), "nobody")
+	var apiErr *provider.APIError
+	if !errors.Is(err, provider.ErrNotFound) || !errors.As(err, &apiErr) {
+		t.Fatalf("got %v, want a *provider.APIError matching provider.ErrNotFound", err)
+	}
+	if apiErr.Message != "user does not exist [uid: 0, name: nobody]" {
+		t.Errorf("got message %q", apiErr.Message)
+	}
+}
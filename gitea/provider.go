@@ -0,0 +1,22 @@
+package gitea
+
+import (
+	"context"
+
+	"github.com/deathrjj/age-gitlab-tool-tui/models"
+)
+
+// Name implements provider.Provider
+func (c *Client) Name() string {
+	return Source
+}
+
+// ListUsers implements provider.Provider
+func (c *Client) ListUsers(ctx context.Context) ([]models.User, error) {
+	return c.FetchUsers(ctx)
+}
+
+// FetchKeys implements provider.Provider, returning the user's SSH keys
+func (c *Client) FetchKeys(ctx context.Context, user models.User) ([]models.Key, error) {
+	return c.FetchUserKeys(ctx, user.Username)
+}
@@ -0,0 +1,225 @@
+package github
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/deathrjj/age-gitlab-tool-tui/cache"
+	"github.com/deathrjj/age-gitlab-tool-tui/models"
+	"github.com/deathrjj/age-gitlab-tool-tui/provider"
+)
+
+// Source is the provider name of users fetched from GitHub
+const Source = "github"
+
+// Client handles GitHub API interactions
+type Client struct {
+	// BaseURL is the web URL keys are fetched from, as https://github.com/<user>.keys
+	BaseURL string
+	// APIURL is the URL of the REST API members are listed with
+	APIURL  string
+	Token   string
+	Org     string
+	Teams   []string
+	Users   []string
+	client  *http.Client
+	cache   *cache.Store
+	limiter provider.RateLimiter
+}
+
+// Configured reports whether GitHub users are configured, by GITHUB_ORG or
+// GITHUB_USERS
+func Configured() bool {
+	return os.Getenv("GITHUB_ORG") != "" || os.Getenv("GITHUB_USERS") != ""
+}
+
+// NewClient creates a new GitHub client from the GITHUB_* environment variables.
+// GITHUB_URL defaults to https://github.com, and GITHUB_API_URL to the API of
+// GITHUB_URL. GITHUB_TOKEN is optional, but needed to list private members.
+// How to connect is configured as for GitLab, see provider.NewHTTPClient.
+func NewClient() (*Client, error) {
+	baseURL := strings.TrimSuffix(os.Getenv("GITHUB_URL"), "/")
+	if baseURL == "" {
+		baseURL = "https://github.com"
+	}
+
+	apiURL := strings.TrimSuffix(os.Getenv("GITHUB_API_URL"), "/")
+	if apiURL == "" {
+		parsed, err := url.Parse(baseURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid GITHUB_URL: %w", err)
+		}
+		if parsed.Host == "github.com" {
+			apiURL = "https://api.github.com"
+		} else {
+			// GitHub Enterprise Server serves its API below /api/v3
+			apiURL = baseURL + "/api/v3"
+		}
+	}
+
+	org := os.Getenv("GITHUB_ORG")
+	teams := provider.SplitList(os.Getenv("GITHUB_TEAMS"))
+	users := provider.SplitList(os.Getenv("GITHUB_USERS"))
+	if org == "" && len(users) == 0 {
+		return nil, fmt.Errorf("GITHUB_ORG or GITHUB_USERS not set")
+	}
+	if org == "" && len(teams) > 0 {
+		return nil, fmt.Errorf("GITHUB_TEAMS needs GITHUB_ORG to be set")
+	}
+
+	token := os.Getenv("GITHUB_TOKEN")
+	store, err := cache.Default(token)
+	if err != nil {
+		return nil, err
+	}
+	httpClient, err := provider.NewHTTPClient("GITHUB")
+	if err != nil {
+		return nil, err
+	}
+
+	return &Client{
+		BaseURL: baseURL,
+		APIURL:  apiURL,
+		Token:   token,
+		Org:     org,
+		Teams:   teams,
+		Users:   users,
+		client:  httpClient,
+		cache:   store,
+	}, nil
+}
+
+// member is a user as listed by the members endpoints
+type member struct {
+	ID    int    `json:"id"`
+	Login string `json:"login"`
+}
+
+// FetchUsers retrieves the members of the configured teams, or of the whole
+// organization if no teams are configured, together with the configured users
+func (c *Client) FetchUsers(ctx context.Context) ([]models.User, error) {
+	var members []member
+	switch {
+	case len(c.Teams) > 0:
+		teamMembers := make([][]member, len(c.Teams))
+		err := provider.Parallel(ctx, len(c.Teams), func(ctx context.Context, i int) error {
+			var err error
+			teamMembers[i], err = fetchAllPages[member](ctx, c, fmt.Sprintf("orgs/%s/teams/%s/members",
+				url.PathEscape(c.Org), url.PathEscape(c.Teams[i])))
+			if err != nil {
+				return fmt.Errorf("failed to fetch members of team %s: %w", c.Teams[i], err)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range teamMembers {
+			members = append(members, m...)
+		}
+	case c.Org != "":
+		orgMembers, err := fetchAllPages[member](ctx, c, "orgs/"+url.PathEscape(c.Org)+"/members")
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch members of organization %s: %w", c.Org, err)
+		}
+		members = orgMembers
+	}
+	for _, login := range c.Users {
+		members = append(members, member{Login: login})
+	}
+
+	// GitHub logins are case-insensitive
+	seen := make(map[string]bool)
+	var users []models.User
+	for _, m := range members {
+		if seen[strings.ToLower(m.Login)] {
+			continue
+		}
+		seen[strings.ToLower(m.Login)] = true
+		users = append(users, models.User{ID: m.ID, Username: m.Login, Source: Source})
+	}
+
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].Username < users[j].Username
+	})
+
+	return users, nil
+}
+
+// FetchUserKeys retrieves the SSH keys a user has published at
+// https://github.com/<user>.keys
+func (c *Client) FetchUserKeys(ctx context.Context, login string) ([]string, error) {
+	endpoint := c.BaseURL + "/" + url.PathEscape(login) + ".keys"
+	resp, err := c.cache.Get(ctx, endpoint, func(req *http.Request) (*http.Response, error) {
+		return c.limiter.Do(c.client, req)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		apiErr := provider.NewAPIError(Source, endpoint, resp.StatusCode, resp.Status, resp.Header, resp.Body)
+		if resp.StatusCode == http.StatusNotFound {
+			return nil, fmt.Errorf("unknown GitHub user %q: %w", login, apiErr)
+		}
+		return nil, apiErr
+	}
+
+	var keys []string
+	scanner := bufio.NewScanner(bytes.NewReader(resp.Body))
+	for scanner.Scan() {
+		if key := strings.TrimSpace(scanner.Text()); key != "" {
+			keys = append(keys, key)
+		}
+	}
+
+	return keys, scanner.Err()
+}
+
+// fetchAllPages retrieves every page of a paginated API endpoint, given
+// relative to the API URL, and concatenates the results. After the first
+// page, as many pages as provider.Concurrency allows are fetched at once.
+func fetchAllPages[T any](ctx context.Context, c *Client, path string) ([]T, error) {
+	perPage := 100
+	return provider.FetchPages(ctx, perPage, func(ctx context.Context, page int) ([]T, error) {
+		endpoint := fmt.Sprintf("%s/%s?page=%d&per_page=%d", c.APIURL, path, page, perPage)
+
+		var items []T
+		if err := c.get(ctx, endpoint, &items); err != nil {
+			return nil, err
+		}
+		return items, nil
+	})
+}
+
+// get performs a GET request against the API, authenticated if a token is
+// set, or answers it from the cache, and decodes the JSON response into v.
+// Error responses are returned as a *provider.APIError.
+func (c *Client) get(ctx context.Context, endpoint string, v interface{}) error {
+	resp, err := c.cache.Get(ctx, endpoint, func(req *http.Request) (*http.Response, error) {
+		req.Header.Add("Accept", "application/vnd.github+json")
+		if c.Token != "" {
+			req.Header.Add("Authorization", "Bearer "+c.Token)
+		}
+		return c.limiter.Do(c.client, req)
+	})
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return provider.NewAPIError(Source, endpoint, resp.StatusCode, resp.Status, resp.Header, resp.Body)
+	}
+
+	if err := json.Unmarshal(resp.Body, v); err != nil {
+		return fmt.Errorf("GET %s: invalid response: %w", endpoint, err)
+	}
+	return nil
+}
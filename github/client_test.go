@@ -0,0 +1,187 @@
+package github
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/deathrjj/age-gitlab-tool-tui/cache"
+	"github.com/deathrjj/age-gitlab-tool-tui/provider"
+)
+
+// newTestClient starts a fake GitHub Enterprise Server with handler and
+// returns a client for it configured by env
+func newTestClient(t *testing.T, handler http.Handler, env map[string]string) *Client {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	t.Setenv("GITHUB_URL", server.URL)
+	for _, name := range []string{"GITHUB_API_URL", "GITHUB_ORG", "GITHUB_TEAMS", "GITHUB_USERS", "GITHUB_TOKEN"} {
+		t.Setenv(name, env[name])
+	}
+
+	client, err := NewClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Every test starts with an empty cache of its own
+	client.cache = cache.New(t.TempDir(), cache.DefaultTTL)
+	return client
+}
+
+// servePages answers with count members named user000, user001 and so on,
+// split into pages as requested
+func servePages(w http.ResponseWriter, r *http.Request, count int) {
+	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+	perPage, _ := strconv.Atoi(r.URL.Query().Get("per_page"))
+	members := []member{}
+	for i := (page - 1) * perPage; i < page*perPage && i < count; i++ {
+		members = append(members, member{ID: i + 1, Login: fmt.Sprintf("user%03d", i)})
+	}
+	json.NewEncoder(w).Encode(members)
+}
+
+func TestFetchUsersFetchesAllPages(t *testing.T) {
+	var mu sync.Mutex
+	requested := make(map[string]int)
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v3/orgs/acme/members", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		mu.Lock()
+		requested[r.URL.Query().Get("page")]++
+		mu.Unlock()
+		servePages(w, r, 250)
+	})
+	client := newTestClient(t, mux, map[string]string{
+		"GITHUB_ORG":   "acme",
+		"GITHUB_USERS": "octocat,USER001",
+		"GITHUB_TOKEN": "secret",
+	})
+
+	users, err := client.FetchUsers(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// USER001 is user001, as logins are case-insensitive
+	if len(users) != 251 {
+		t.Fatalf("got %d users, want 251", len(users))
+	}
+	if users[0].Username != "octocat" || users[1].Username != "user000" || users[250].Username != "user249" {
+		t.Errorf("users are not sorted: %s, %s ... %s", users[0].Username, users[1].Username, users[250].Username)
+	}
+	if users[1].Source != Source || users[1].ID != 1 {
+		t.Errorf("user000 = %+v, want ID 1 from %s", users[1], Source)
+	}
+	for _, page := range []string{"1", "2", "3"} {
+		if requested[page] != 1 {
+			t.Errorf("page %s requested %d times, want once", page, requested[page])
+		}
+	}
+}
+
+func TestFetchUsersOfTeams(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v3/orgs/acme/teams/ops/members", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode([]member{{ID: 1, Login: "alice"}, {ID: 2, Login: "bob"}})
+	})
+	mux.HandleFunc("GET /api/v3/orgs/acme/teams/security/members", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode([]member{{ID: 2, Login: "bob"}, {ID: 3, Login: "carol"}})
+	})
+	client := newTestClient(t, mux, map[string]string{"GITHUB_ORG": "acme", "GITHUB_TEAMS": "ops, security"})
+
+	users, err := client.FetchUsers(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var logins []string
+	for _, user := range users {
+		logins = append(logins, user.Username)
+	}
+	if fmt.Sprint(logins) != "[alice bob carol]" {
+		t.Errorf("got users %v, want [alice bob carol]", logins)
+	}
+}
+
+func TestFetchUsersRetriesWhenRateLimited(t *testing.T) {
+	var mu sync.Mutex
+	attempts := 0
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v3/orgs/acme/members", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		attempts++
+		limited := attempts == 1
+		mu.Unlock()
+
+		// GitHub answers 403 Forbidden once the rate limit is exhausted
+		if limited {
+			w.Header().Set("X-RateLimit-Remaining", "0")
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).Encode(map[string]string{"message": "API rate limit exceeded"})
+			return
+		}
+		servePages(w, r, 2)
+	})
+	client := newTestClient(t, mux, map[string]string{"GITHUB_ORG": "acme"})
+
+	users, err := client.FetchUsers(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 2 || attempts != 2 {
+		t.Errorf("got %d users after %d attempts, want 2 users after 2 attempts", len(users), attempts)
+	}
+}
+
+func TestFetchUsersReturnsAPIError(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v3/orgs/acme/members", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]string{"message": "Bad credentials"})
+	})
+	client := newTestClient(t, mux, map[string]string{"GITHUB_ORG": "acme", "GITHUB_TOKEN": "wrong"})
+
+	_, err := client.FetchUsers(context.Background())
+	if !errors.Is(err, provider.ErrUnauthorized) {
+		t.Fatalf("got %v, want an error matching provider.ErrUnauthorized", err)
+	}
+	var apiErr *provider.APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("got %T, want a *provider.APIError", err)
+	}
+	if apiErr.Source != Source || apiErr.Path != "/api/v3/orgs/acme/members" || apiErr.Message != "Bad credentials" {
+		t.Errorf("got %+v", apiErr)
+	}
+}
+
+func TestFetchUserKeys(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /alice.keys", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIA alice\n\nssh-rsa AAAAB3NzaC1yc2EAAAADAQABAAABAQ\n")
+	})
+	client := newTestClient(t, mux, map[string]string{"GITHUB_USERS": "alice"})
+
+	keys, err := client.FetchUserKeys(context.Background(), "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 || keys[0] != "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIA alice" {
+		t.Errorf("got keys %q", keys)
+	}
+
+	_, err = client.FetchUserKeys(context.Background(), "nobody")
+	if !errors.Is(err, provider.ErrNotFound) {
+		t.Errorf("got %v for an unknown user, want an error matching provider.ErrNotFound", err)
+	}
+}
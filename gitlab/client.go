@@ -1,22 +1,35 @@
 package gitlab
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"net/http"
+	"net/url"
 	"os"
+	"regexp"
 	"sort"
-	"time"
+	"strings"
 
+	"github.com/deathrjj/age-gitlab-tool-tui/cache"
 	"github.com/deathrjj/age-gitlab-tool-tui/models"
+	"github.com/deathrjj/age-gitlab-tool-tui/provider"
+	"golang.org/x/oauth2"
 )
 
+// Source is the provider name of users fetched from GitLab
+const Source = models.GitLabSource
+
 // Client handles GitLab API interactions
 type Client struct {
 	BaseURL string
 	Token   string
 	client  *http.Client
+	cache   *cache.Store
+	limiter provider.RateLimiter
+
+	// tokenSource provides OAuth tokens, used instead of Token if set
+	tokenSource oauth2.TokenSource
 }
 
 // NewClient creates a new GitLab API client
@@ -24,54 +37,36 @@ func NewClient() (*Client, error) {
 	baseURL := os.Getenv("GITLAB_URL")
 	token := os.Getenv("GITLAB_TOKEN")
 	
-	if baseURL == "" || token == "" {
+	// Offline, everything comes from the cache and no token is needed
+	if baseURL == "" || (token == "" && !cache.Offline()) {
 		return nil, fmt.Errorf("GITLAB_URL or GITLAB_TOKEN not set")
 	}
+
+	store, err := cache.Default(token)
+	if err != nil {
+		return nil, err
+	}
+	httpClient, err := NewHTTPClient()
+	if err != nil {
+		return nil, err
+	}
 	
 	return &Client{
 		BaseURL: baseURL,
 		Token:   token,
-		client: &http.Client{
-			Timeout: 10 * time.Second,
-		},
+		client:  httpClient,
+		cache:   store,
 	}, nil
 }
 
 // FetchUsers retrieves GitLab users page by page
-func (c *Client) FetchUsers() ([]models.User, error) {
-	var users []models.User
-	perPage := 100
-	
-	for page := 1; ; page++ {
-		url := fmt.Sprintf("%s/api/v4/users?active=true&humans=true&exclude_external=true&page=%d&per_page=%d", 
-			c.BaseURL, page, perPage)
-		
-		req, err := http.NewRequest("GET", url, nil)
-		if err != nil {
-			return nil, err
-		}
-		
-		req.Header.Add("PRIVATE-TOKEN", c.Token)
-		resp, err := c.client.Do(req)
-		if err != nil {
-			return nil, err
-		}
-		
-		body, err := ioutil.ReadAll(resp.Body)
-		resp.Body.Close()
-		if err != nil {
-			return nil, err
-		}
-		
-		var pageUsers []models.User
-		if err := json.Unmarshal(body, &pageUsers); err != nil {
-			return nil, err
-		}
-		
-		users = append(users, pageUsers...)
-		if len(pageUsers) < perPage {
-			break
-		}
+func (c *Client) FetchUsers(ctx context.Context) ([]models.User, error) {
+	users, err := fetchAllPages[models.User](ctx, c, "users?active=true&humans=true&exclude_external=true")
+	if err != nil {
+		return nil, err
+	}
+	for i := range users {
+		users[i].Source = Source
 	}
 	
 	// Sort users by username
@@ -82,39 +77,170 @@ func (c *Client) FetchUsers() ([]models.User, error) {
 	return users, nil
 }
 
-// FetchUserKeys retrieves the SSH keys for a given user ID
-func (c *Client) FetchUserKeys(userID int) ([]string, error) {
-	url := fmt.Sprintf("%s/api/v4/users/%d/keys", c.BaseURL, userID)
-	
-	req, err := http.NewRequest("GET", url, nil)
+// FetchUserKeys retrieves the SSH keys for a given user ID, with their titles,
+// dates and usage types
+func (c *Client) FetchUserKeys(ctx context.Context, userID int) ([]models.Key, error) {
+	var keys []models.Key
+	if err := c.get(ctx, fmt.Sprintf("%s/api/v4/users/%d/keys", c.BaseURL, userID), &keys); err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// ageRecipientPattern matches native age X25519 recipients in free text
+var ageRecipientPattern = regexp.MustCompile(`\bage1[02-9ac-hj-np-z]{58}\b`)
+
+// FetchUserAgeRecipients retrieves the native age recipients ("age1...") a user
+// has published in the bio of their GitLab profile
+func (c *Client) FetchUserAgeRecipients(ctx context.Context, userID int) ([]string, error) {
+	var profile struct {
+		Bio string `json:"bio"`
+	}
+
+	if err := c.get(ctx, fmt.Sprintf("%s/api/v4/users/%d", c.BaseURL, userID), &profile); err != nil {
+		return nil, err
+	}
+
+	return ageRecipientPattern.FindAllString(profile.Bio, -1), nil
+}
+
+// FetchGroups retrieves all groups visible to the token's user, sorted by full path
+func (c *Client) FetchGroups(ctx context.Context) ([]models.Group, error) {
+	groups, err := fetchAllPages[models.Group](ctx, c, "groups?all_available=true")
 	if err != nil {
 		return nil, err
 	}
-	
-	req.Header.Add("PRIVATE-TOKEN", c.Token)
-	resp, err := c.client.Do(req)
+
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i].FullPath < groups[j].FullPath
+	})
+
+	return groups, nil
+}
+
+// FetchGroupMembers retrieves the active members of a group, given by ID or full
+// path, including members inherited from parent groups and the members of all
+// of its subgroups
+func (c *Client) FetchGroupMembers(ctx context.Context, group string) ([]models.User, error) {
+	groupPath := "groups/" + url.PathEscape(group)
+
+	subgroups, err := fetchAllPages[models.Group](ctx, c, groupPath+"/descendant_groups")
 	if err != nil {
 		return nil, err
 	}
-	
-	body, err := ioutil.ReadAll(resp.Body)
-	resp.Body.Close()
+
+	members, err := fetchAllPages[models.Member](ctx, c, groupPath+"/members/all")
 	if err != nil {
 		return nil, err
 	}
-	
-	var keysResp []struct {
-		Key string `json:"key"`
+	for _, subgroup := range subgroups {
+		subgroupMembers, err := fetchAllPages[models.Member](ctx, c, fmt.Sprintf("groups/%d/members/all", subgroup.ID))
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, subgroupMembers...)
 	}
-	
-	if err := json.Unmarshal(body, &keysResp); err != nil {
+
+	return activeUsers(members, 0), nil
+}
+
+// FetchProjects retrieves the non-archived projects the token's user is a member of, sorted by path
+func (c *Client) FetchProjects(ctx context.Context) ([]models.Project, error) {
+	projects, err := fetchAllPages[models.Project](ctx, c, "projects?membership=true&simple=true&archived=false")
+	if err != nil {
 		return nil, err
 	}
-	
-	var keys []string
-	for _, k := range keysResp {
-		keys = append(keys, k.Key)
+
+	sort.Slice(projects, func(i, j int) bool {
+		return projects[i].PathWithNamespace < projects[j].PathWithNamespace
+	})
+
+	return projects, nil
+}
+
+// FetchProjectMembers retrieves the active members of a project, given by ID or
+// full path, that have at least the given access level, including members
+// inherited from the project's groups
+func (c *Client) FetchProjectMembers(ctx context.Context, project string, minAccess models.AccessLevel) ([]models.User, error) {
+	members, err := fetchAllPages[models.Member](ctx, c, "projects/"+url.PathEscape(project)+"/members/all")
+	if err != nil {
+		return nil, err
 	}
-	
-	return keys, nil
-} 
+
+	return activeUsers(members, minAccess), nil
+}
+
+// activeUsers returns the distinct active users among members that have at
+// least the given access level, sorted by username
+func activeUsers(members []models.Member, minAccess models.AccessLevel) []models.User {
+	seen := make(map[int]bool)
+	var users []models.User
+	for _, member := range members {
+		if member.State != "active" || member.AccessLevel < minAccess || seen[member.ID] {
+			continue
+		}
+		seen[member.ID] = true
+		users = append(users, models.User{ID: member.ID, Username: member.Username, Source: Source})
+	}
+
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].Username < users[j].Username
+	})
+
+	return users
+}
+
+// fetchAllPages retrieves every page of a paginated API endpoint, given
+// relative to /api/v4, and concatenates the results. After the first page,
+// as many pages as provider.Concurrency allows are fetched at once, until one
+// of them is not full.
+func fetchAllPages[T any](ctx context.Context, c *Client, path string) ([]T, error) {
+	perPage := 100
+
+	separator := "?"
+	if strings.Contains(path, "?") {
+		separator = "&"
+	}
+	return provider.FetchPages(ctx, perPage, func(ctx context.Context, page int) ([]T, error) {
+		endpoint := fmt.Sprintf("%s/api/v4/%s%spage=%d&per_page=%d",
+			c.BaseURL, path, separator, page, perPage)
+
+		var items []T
+		if err := c.get(ctx, endpoint, &items); err != nil {
+			return nil, err
+		}
+		return items, nil
+	})
+}
+
+// get performs an authenticated GET request, or answers it from the cache,
+// and decodes the JSON response into v. Error responses are returned as an
+// *APIError.
+func (c *Client) get(ctx context.Context, endpoint string, v interface{}) error {
+	resp, err := c.cache.Get(ctx, endpoint, func(req *http.Request) (*http.Response, error) {
+		if c.tokenSource != nil {
+			token, err := c.tokenSource.Token()
+			if err != nil {
+				return nil, err
+			}
+			token.SetAuthHeader(req)
+		} else {
+			req.Header.Add("PRIVATE-TOKEN", c.Token)
+		}
+		return c.limiter.Do(c.client, req)
+	})
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		apiErr := newAPIError(endpoint, resp.StatusCode, resp.Status, resp.Header, resp.Body)
+		apiErr.OAuth = c.tokenSource != nil
+		return apiErr
+	}
+
+	if err := json.Unmarshal(resp.Body, v); err != nil {
+		return fmt.Errorf("GET %s: invalid response: %w", apiPath(endpoint), err)
+	}
+	return nil
+}
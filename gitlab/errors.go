@@ -0,0 +1,164 @@
+package gitlab
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/deathrjj/age-gitlab-tool-tui/provider"
+)
+
+// Errors that an *APIError matches with errors.Is, by status code. They are
+// the errors of the other providers' API errors.
+var (
+	ErrUnauthorized = provider.ErrUnauthorized
+	ErrForbidden    = provider.ErrForbidden
+	ErrNotFound     = provider.ErrNotFound
+	ErrRateLimited  = provider.ErrRateLimited
+)
+
+// APIError is an error response of the GitLab API
+type APIError struct {
+	// Path is the requested API path relative to /api/v4, such as "users"
+	Path       string
+	StatusCode int
+	// Status is the status line, such as "403 Forbidden"
+	Status string
+	// Message is the message GitLab sent, if any
+	Message string
+	// Code is the OAuth error code, such as "insufficient_scope" or
+	// "invalid_token"
+	Code string
+	// Scopes lists the token scopes that would allow the request, any of
+	// them, if the token lacks a scope
+	Scopes []string
+	// RetryAfter is how long to wait before retrying a rate limited request,
+	// if GitLab said so
+	RetryAfter time.Duration
+	// OAuth reports whether the request was made with an OAuth token rather
+	// than a personal access token
+	OAuth bool
+}
+
+// Error returns the path, status and message, such as "GET users: 403
+// Forbidden: insufficient_scope: The request requires higher privileges..."
+func (e *APIError) Error() string {
+	text := fmt.Sprintf("GET %s: %s", e.Path, e.Status)
+	if e.Code != "" {
+		text += ": " + e.Code
+	}
+	if e.Message != "" && !strings.EqualFold(e.Message, e.Status) {
+		text += ": " + e.Message
+	}
+	return text
+}
+
+// Unwrap returns the error for the status code, such as ErrNotFound, or nil
+func (e *APIError) Unwrap() error {
+	switch e.StatusCode {
+	case http.StatusUnauthorized:
+		return ErrUnauthorized
+	case http.StatusForbidden:
+		return ErrForbidden
+	case http.StatusNotFound:
+		return ErrNotFound
+	case http.StatusTooManyRequests:
+		return ErrRateLimited
+	}
+	return nil
+}
+
+// InsufficientScope reports whether the request was denied because the token
+// lacks a scope
+func (e *APIError) InsufficientScope() bool {
+	return e.Code == "insufficient_scope"
+}
+
+// newAPIError decodes an error response, whose body is JSON such as
+// {"message": "404 User Not Found"} or {"error": "insufficient_scope",
+// "error_description": "...", "scope": "api read_api"}
+func newAPIError(endpoint string, statusCode int, status string, header http.Header, body []byte) *APIError {
+	e := &APIError{Path: apiPath(endpoint), StatusCode: statusCode, Status: status}
+	if e.Status == "" {
+		e.Status = fmt.Sprintf("%d %s", statusCode, http.StatusText(statusCode))
+	}
+
+	var response struct {
+		Message          json.RawMessage `json:"message"`
+		Error            string          `json:"error"`
+		ErrorDescription string          `json:"error_description"`
+		Scope            string          `json:"scope"`
+	}
+	if err := json.Unmarshal(body, &response); err == nil {
+		// The message is usually a string, but validation errors are
+		// objects, which are shown as they are
+		if err := json.Unmarshal(response.Message, &e.Message); err != nil {
+			e.Message = string(response.Message)
+		}
+		if e.Message == "" {
+			e.Message = response.ErrorDescription
+		}
+		e.Code = response.Error
+		e.Scopes = strings.Fields(response.Scope)
+	}
+	if statusCode == http.StatusTooManyRequests {
+		e.RetryAfter = provider.RetryDelay(header, 0)
+	}
+
+	return e
+}
+
+// apiPath returns the path of an API endpoint relative to /api/v4, without
+// the query
+func apiPath(endpoint string) string {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return endpoint
+	}
+	if _, path, ok := strings.Cut(u.Path, "/api/v4/"); ok {
+		return path
+	}
+	return u.Path
+}
+
+// Advice returns what to do about an error of the GitLab API, such as
+// "The token lacks the read_api or read_user scope...", or "" if err is not
+// an *APIError or there is no advice
+func Advice(err error) string {
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		return ""
+	}
+
+	switch {
+	case apiErr.StatusCode == http.StatusUnauthorized && apiErr.OAuth:
+		return "GitLab rejected the OAuth token. Log in again with \"age-gitlab-tool-tui login\"."
+	case apiErr.StatusCode == http.StatusUnauthorized:
+		return "GitLab rejected the token. Check that GITLAB_TOKEN is correct and has not expired or been revoked."
+	case apiErr.InsufficientScope() && len(apiErr.Scopes) > 0:
+		return fmt.Sprintf("The token lacks the %s scope. Create a token with one of these scopes.", joinOr(apiErr.Scopes))
+	case apiErr.InsufficientScope():
+		return "The token lacks a scope this request needs. Create a token with the read_api scope."
+	case apiErr.StatusCode == http.StatusForbidden:
+		return "GitLab denied access. The user of the token may not be allowed to see this, or the instance only allows administrators to list users."
+	case apiErr.StatusCode == http.StatusNotFound:
+		return "Check that GITLAB_URL points to the GitLab instance, and that the group, project or user exists and is visible to the user of the token."
+	case apiErr.StatusCode == http.StatusTooManyRequests && apiErr.RetryAfter > 0:
+		return fmt.Sprintf("GitLab's rate limit was reached. Try again in %s.", apiErr.RetryAfter.Round(time.Second))
+	case apiErr.StatusCode == http.StatusTooManyRequests:
+		return "GitLab's rate limit was reached. Try again later."
+	}
+	return ""
+}
+
+// joinOr joins words as in "a, b or c"
+func joinOr(words []string) string {
+	if len(words) < 2 {
+		return strings.Join(words, "")
+	}
+	return strings.Join(words[:len(words)-1], ", ") + " or " + words[len(words)-1]
+}
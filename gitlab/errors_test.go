@@ -0,0 +1,186 @@
+package gitlab
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestNewAPIError(t *testing.T) {
+	tests := []struct {
+		name       string
+		endpoint   string
+		statusCode int
+		status     string
+		header     http.Header
+		body       string
+		want       APIError
+	}{
+		{
+			name:       "string message",
+			endpoint:   "https://gitlab.example.com/api/v4/users/42?per_page=100",
+			statusCode: 404,
+			status:     "404 Not Found",
+			body:       `{"message": "404 User Not Found"}`,
+			want:       APIError{Path: "users/42", StatusCode: 404, Status: "404 Not Found", Message: "404 User Not Found"},
+		},
+		{
+			name:       "object message",
+			endpoint:   "https://gitlab.example.com/api/v4/groups",
+			statusCode: 400,
+			status:     "400 Bad Request",
+			body:       `{"message": {"name": ["is too short"]}}`,
+			want:       APIError{Path: "groups", StatusCode: 400, Status: "400 Bad Request", Message: `{"name": ["is too short"]}`},
+		},
+		{
+			name:       "insufficient scope",
+			endpoint:   "https://gitlab.example.com/api/v4/users",
+			statusCode: 403,
+			status:     "403 Forbidden",
+			body:       `{"error": "insufficient_scope", "error_description": "The request requires higher privileges than provided by the access token.", "scope": "api read_api"}`,
+			want: APIError{Path: "users", StatusCode: 403, Status: "403 Forbidden", Code: "insufficient_scope",
+				Message: "The request requires higher privileges than provided by the access token.", Scopes: []string{"api", "read_api"}},
+		},
+		{
+			name:       "message and description",
+			endpoint:   "https://gitlab.example.com/api/v4/user",
+			statusCode: 401,
+			status:     "401 Unauthorized",
+			body:       `{"message": "401 Unauthorized", "error": "invalid_token", "error_description": "Token was revoked."}`,
+			want:       APIError{Path: "user", StatusCode: 401, Status: "401 Unauthorized", Code: "invalid_token", Message: "401 Unauthorized"},
+		},
+		{
+			name:       "retry after",
+			endpoint:   "https://gitlab.example.com/api/v4/users",
+			statusCode: 429,
+			header:     http.Header{"Retry-After": {"30"}},
+			body:       `Retry later`,
+			want:       APIError{Path: "users", StatusCode: 429, Status: "429 Too Many Requests", RetryAfter: 30 * time.Second},
+		},
+		{
+			name:       "retry after without rate limit",
+			endpoint:   "https://gitlab.example.com/api/v4/users",
+			statusCode: 503,
+			status:     "503 Service Unavailable",
+			header:     http.Header{"Retry-After": {"30"}},
+			want:       APIError{Path: "users", StatusCode: 503, Status: "503 Service Unavailable"},
+		},
+		{
+			name:       "relative URL",
+			endpoint:   "projects/acme%2Fapp/members/all",
+			statusCode: 500,
+			body:       `<html>Internal Server Error</html>`,
+			want:       APIError{Path: "projects/acme/app/members/all", StatusCode: 500, Status: "500 Internal Server Error"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := newAPIError(test.endpoint, test.statusCode, test.status, test.header, []byte(test.body))
+			// No scopes and an empty list of them are the same
+			if len(got.Scopes) == 0 {
+				got.Scopes = nil
+			}
+			if !reflect.DeepEqual(*got, test.want) {
+				t.Errorf("got %+v, want %+v", *got, test.want)
+			}
+		})
+	}
+}
+
+func TestAPIErrorError(t *testing.T) {
+	tests := []struct {
+		err  APIError
+		want string
+	}{
+		{APIError{Path: "users", Status: "404 Not Found", Message: "404 User Not Found"}, "GET users: 404 Not Found: 404 User Not Found"},
+		{APIError{Path: "user", Status: "401 Unauthorized", Message: "401 unauthorized"}, "GET user: 401 Unauthorized"},
+		{APIError{Path: "users", Status: "403 Forbidden", Code: "insufficient_scope", Message: "Higher privileges needed."},
+			"GET users: 403 Forbidden: insufficient_scope: Higher privileges needed."},
+		{APIError{Path: "users", Status: "429 Too Many Requests"}, "GET users: 429 Too Many Requests"},
+	}
+	for _, test := range tests {
+		if got := test.err.Error(); got != test.want {
+			t.Errorf("got %q, want %q", got, test.want)
+		}
+	}
+}
+
+func TestAPIErrorUnwrap(t *testing.T) {
+	tests := []struct {
+		statusCode int
+		want       error
+	}{
+		{401, ErrUnauthorized},
+		{403, ErrForbidden},
+		{404, ErrNotFound},
+		{429, ErrRateLimited},
+		{500, nil},
+	}
+	for _, test := range tests {
+		err := fmt.Errorf("failed to fetch users: %w", &APIError{StatusCode: test.statusCode})
+		for _, sentinel := range []error{ErrUnauthorized, ErrForbidden, ErrNotFound, ErrRateLimited} {
+			if got := errors.Is(err, sentinel); got != (sentinel == test.want) {
+				t.Errorf("status %d: errors.Is(%v) = %v", test.statusCode, sentinel, got)
+			}
+		}
+	}
+}
+
+func TestAdvice(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{"OAuth token rejected", &APIError{StatusCode: 401, OAuth: true},
+			"GitLab rejected the OAuth token. Log in again with \"age-gitlab-tool-tui login\"."},
+		{"token rejected", &APIError{StatusCode: 401},
+			"GitLab rejected the token. Check that GITLAB_TOKEN is correct and has not expired or been revoked."},
+		{"one scope", &APIError{StatusCode: 403, Code: "insufficient_scope", Scopes: []string{"read_api"}},
+			"The token lacks the read_api scope. Create a token with one of these scopes."},
+		{"several scopes", &APIError{StatusCode: 403, Code: "insufficient_scope", Scopes: []string{"api", "read_api", "read_user"}},
+			"The token lacks the api, read_api or read_user scope. Create a token with one of these scopes."},
+		{"unknown scope", &APIError{StatusCode: 403, Code: "insufficient_scope"},
+			"The token lacks a scope this request needs. Create a token with the read_api scope."},
+		{"forbidden", &APIError{StatusCode: 403},
+			"GitLab denied access. The user of the token may not be allowed to see this, or the instance only allows administrators to list users."},
+		{"not found", &APIError{StatusCode: 404},
+			"Check that GITLAB_URL points to the GitLab instance, and that the group, project or user exists and is visible to the user of the token."},
+		{"rate limited", &APIError{StatusCode: 429, RetryAfter: 1500 * time.Millisecond},
+			"GitLab's rate limit was reached. Try again in 2s."},
+		{"rate limited without delay", &APIError{StatusCode: 429},
+			"GitLab's rate limit was reached. Try again later."},
+		{"wrapped", fmt.Errorf("failed to fetch users: %w", &APIError{StatusCode: 404}),
+			"Check that GITLAB_URL points to the GitLab instance, and that the group, project or user exists and is visible to the user of the token."},
+		{"server error", &APIError{StatusCode: 500}, ""},
+		{"other error", errors.New("connection refused"), ""},
+		{"nil", nil, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := Advice(test.err); got != test.want {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestJoinOr(t *testing.T) {
+	tests := []struct {
+		words []string
+		want  string
+	}{
+		{nil, ""},
+		{[]string{"api"}, "api"},
+		{[]string{"api", "read_api"}, "api or read_api"},
+		{[]string{"api", "read_api", "read_user"}, "api, read_api or read_user"},
+	}
+	for _, test := range tests {
+		if got := joinOr(test.words); got != test.want {
+			t.Errorf("joinOr(%q) = %q, want %q", test.words, got, test.want)
+		}
+	}
+}
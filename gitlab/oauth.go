@@ -0,0 +1,272 @@
+package gitlab
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/deathrjj/age-gitlab-tool-tui/cache"
+	"golang.org/x/oauth2"
+)
+
+// DefaultRedirectURI is the callback the authorization code flow listens on
+// unless another one is configured. It must be one of the redirect URIs of the
+// OAuth application in GitLab.
+const DefaultRedirectURI = "http://127.0.0.1:7171/callback"
+
+// DefaultScopes are the scopes requested at login, enough to list users,
+// groups, projects and SSH keys
+var DefaultScopes = []string{"read_api"}
+
+// OAuthCredential is what is stored after logging in with OAuth: the token,
+// including the refresh token, and the ID of the application it was issued
+// to, which is needed to refresh it
+type OAuthCredential struct {
+	ClientID string        `json:"client_id"`
+	Token    *oauth2.Token `json:"token"`
+}
+
+// OAuthConfig returns the OAuth configuration of a GitLab application.
+// The application must not be confidential, as no client secret is used.
+func OAuthConfig(baseURL, clientID, redirectURI string, scopes []string) *oauth2.Config {
+	baseURL = strings.TrimRight(baseURL, "/")
+	if len(scopes) == 0 {
+		scopes = DefaultScopes
+	}
+	return &oauth2.Config{
+		ClientID: clientID,
+		Endpoint: oauth2.Endpoint{
+			AuthURL:       baseURL + "/oauth/authorize",
+			TokenURL:      baseURL + "/oauth/token",
+			DeviceAuthURL: baseURL + "/oauth/authorize_device",
+			AuthStyle:     oauth2.AuthStyleInParams,
+		},
+		RedirectURL: redirectURI,
+		Scopes:      scopes,
+	}
+}
+
+// LoginWithBrowser runs the authorization code flow with PKCE. It listens on
+// the redirect URI of cfg, which must be a local http URL, calls open with
+// the URL the user has to visit and waits until GitLab redirects back or ctx
+// is done.
+func LoginWithBrowser(ctx context.Context, cfg *oauth2.Config, open func(authURL string)) (*oauth2.Token, error) {
+	redirect, err := url.Parse(cfg.RedirectURL)
+	if err != nil || redirect.Scheme != "http" || redirect.Host == "" {
+		return nil, fmt.Errorf("redirect URI %q must be a local http URL", cfg.RedirectURL)
+	}
+	ctx, err = withHTTPClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	listener, err := net.Listen("tcp", redirect.Host)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen for the OAuth callback: %w", err)
+	}
+	defer listener.Close()
+
+	state, err := randomState()
+	if err != nil {
+		return nil, err
+	}
+	verifier := oauth2.GenerateVerifier()
+
+	type result struct {
+		code string
+		err  error
+	}
+	results := make(chan result, 1)
+
+	path := redirect.Path
+	if path == "" {
+		path = "/"
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		var res result
+		switch {
+		case query.Get("state") != state:
+			res.err = fmt.Errorf("OAuth callback with unexpected state")
+		case query.Get("error") != "":
+			res.err = fmt.Errorf("login failed: %s %s", query.Get("error"), query.Get("error_description"))
+		case query.Get("code") == "":
+			res.err = fmt.Errorf("OAuth callback without authorization code")
+		default:
+			res.code = query.Get("code")
+		}
+
+		if res.err != nil {
+			http.Error(w, res.err.Error(), http.StatusBadRequest)
+		} else {
+			fmt.Fprintln(w, "Logged in to GitLab. You can close this window and return to the terminal.")
+		}
+		select {
+		case results <- res:
+		default:
+		}
+	})
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go server.Serve(listener)
+	defer server.Close()
+
+	open(cfg.AuthCodeURL(state, oauth2.S256ChallengeOption(verifier)))
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case res := <-results:
+		if res.err != nil {
+			return nil, res.err
+		}
+		token, err := cfg.Exchange(ctx, res.code, oauth2.VerifierOption(verifier))
+		if err != nil {
+			return nil, fmt.Errorf("failed to exchange the authorization code: %w", err)
+		}
+		return token, nil
+	}
+}
+
+// LoginWithDevice runs the device authorization grant, supported by newer
+// GitLab versions. show is called with the code the user has to enter and
+// where, and the token is returned once the user has approved the login.
+func LoginWithDevice(ctx context.Context, cfg *oauth2.Config, show func(auth *oauth2.DeviceAuthResponse)) (*oauth2.Token, error) {
+	ctx, err := withHTTPClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	auth, err := cfg.DeviceAuth(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start device login: %w", err)
+	}
+	show(auth)
+
+	token, err := cfg.DeviceAccessToken(ctx, auth)
+	if err != nil {
+		return nil, fmt.Errorf("device login failed: %w", err)
+	}
+	return token, nil
+}
+
+// withHTTPClient returns a context that makes the OAuth requests use the HTTP
+// client configured for GitLab, with its CAs, client certificate and proxy
+func withHTTPClient(ctx context.Context) (context.Context, error) {
+	httpClient, err := NewHTTPClient()
+	if err != nil {
+		return nil, err
+	}
+	return context.WithValue(ctx, oauth2.HTTPClient, httpClient), nil
+}
+
+// NewOAuthClient creates a GitLab API client that authenticates with an OAuth
+// token, refreshing it when it expires. save is called with every refreshed
+// credential, as GitLab invalidates the previous refresh token, and warn, if
+// not nil, with the error if it cannot be saved.
+func NewOAuthClient(baseURL string, credential *OAuthCredential, save func(*OAuthCredential) error, warn func(error)) (*Client, error) {
+	if baseURL == "" {
+		return nil, fmt.Errorf("GITLAB_URL not set")
+	}
+	if credential == nil || credential.Token == nil {
+		return nil, errors.New("no OAuth token")
+	}
+
+	// Access tokens change with every refresh, so responses are cached for
+	// the OAuth application instead
+	store, err := cache.Default("oauth " + credential.ClientID)
+	if err != nil {
+		return nil, err
+	}
+	httpClient, err := NewHTTPClient()
+	if err != nil {
+		return nil, err
+	}
+
+	client := &Client{
+		BaseURL: baseURL,
+		client:  httpClient,
+		cache:   store,
+	}
+
+	// Refresh requests use the same HTTP client as API requests
+	ctx := context.WithValue(context.Background(), oauth2.HTTPClient, client.client)
+	cfg := OAuthConfig(baseURL, credential.ClientID, "", nil)
+	client.tokenSource = &savingTokenSource{
+		source:   cfg.TokenSource(ctx, credential.Token),
+		clientID: credential.ClientID,
+		last:     credential.Token.AccessToken,
+		save:     save,
+		warn:     warn,
+	}
+
+	return client, nil
+}
+
+// savingTokenSource passes on the tokens of source and saves every new one
+type savingTokenSource struct {
+	mu       sync.Mutex
+	source   oauth2.TokenSource
+	clientID string
+	last     string
+	save     func(*OAuthCredential) error
+	warn     func(error)
+}
+
+// Token returns a valid token, refreshing it if needed. A refreshed token
+// that cannot be saved is still used, the next run then has to log in again,
+// which warn is told about.
+func (s *savingTokenSource) Token() (*oauth2.Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	token, err := s.source.Token()
+	if err != nil {
+		return nil, fmt.Errorf("failed to refresh the GitLab OAuth token, log in again: %w", err)
+	}
+	if token.AccessToken != s.last {
+		s.last = token.AccessToken
+		if s.save != nil {
+			err := s.save(&OAuthCredential{ClientID: s.clientID, Token: token})
+			if err != nil && s.warn != nil {
+				s.warn(fmt.Errorf("failed to save the refreshed GitLab OAuth token, the next run has to log in again: %w", err))
+			}
+		}
+	}
+	return token, nil
+}
+
+// OpenBrowser opens a URL in the default web browser, without waiting for it
+func OpenBrowser(url string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("open", url)
+	case "windows":
+		cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", url)
+	default:
+		cmd = exec.Command("xdg-open", url)
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	go cmd.Wait()
+	return nil
+}
+
+// randomState returns a random value to tie the OAuth callback to the request
+func randomState() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
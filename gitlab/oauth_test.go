@@ -0,0 +1,122 @@
+package gitlab
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/deathrjj/age-gitlab-tool-tui/cache"
+	"golang.org/x/oauth2"
+)
+
+// newOAuthServer starts a fake GitLab that hands out new-access for the
+// refresh token old-refresh and lists users to requests with it
+func newOAuthServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /oauth/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("grant_type") != "refresh_token" || r.FormValue("refresh_token") != "old-refresh" ||
+			r.FormValue("client_id") != "app" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token":  "new-access",
+			"refresh_token": "new-refresh",
+			"token_type":    "Bearer",
+			"expires_in":    7200,
+		})
+	})
+	mux.HandleFunc("GET /api/v4/users", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer new-access" {
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]string{"message": "401 Unauthorized"})
+			return
+		}
+		json.NewEncoder(w).Encode([]map[string]interface{}{{"id": 1, "username": "alice"}})
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+// newTestOAuthClient creates an OAuth client for server with an empty cache
+// of its own, so every request reaches the server
+func newTestOAuthClient(t *testing.T, server *httptest.Server, save func(*OAuthCredential) error, warn func(error)) *Client {
+	t.Helper()
+	client, err := NewOAuthClient(server.URL, expiredCredential(), save, warn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.cache = cache.New(t.TempDir(), cache.DefaultTTL)
+	return client
+}
+
+// expiredCredential returns a credential whose access token has to be
+// refreshed before it is used
+func expiredCredential() *OAuthCredential {
+	return &OAuthCredential{ClientID: "app", Token: &oauth2.Token{
+		AccessToken:  "old-access",
+		RefreshToken: "old-refresh",
+		TokenType:    "Bearer",
+		Expiry:       time.Now().Add(-time.Hour),
+	}}
+}
+
+func TestOAuthClientSavesRefreshedToken(t *testing.T) {
+	server := newOAuthServer(t)
+
+	var saved []*OAuthCredential
+	client := newTestOAuthClient(t, server, func(credential *OAuthCredential) error {
+		saved = append(saved, credential)
+		return nil
+	}, func(err error) {
+		t.Errorf("unexpected warning: %v", err)
+	})
+
+	users, err := client.FetchUsers(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 1 || users[0].Username != "alice" {
+		t.Errorf("users = %+v, want alice", users)
+	}
+
+	if len(saved) != 1 {
+		t.Fatalf("saved %d credentials, want 1", len(saved))
+	}
+	if saved[0].ClientID != "app" || saved[0].Token.AccessToken != "new-access" ||
+		saved[0].Token.RefreshToken != "new-refresh" {
+		t.Errorf("saved %+v, want the refreshed token", saved[0].Token)
+	}
+}
+
+func TestOAuthClientWarnsIfRefreshedTokenCannotBeSaved(t *testing.T) {
+	server := newOAuthServer(t)
+
+	errSave := errors.New("disk full")
+	var warnings []error
+	client := newTestOAuthClient(t, server, func(credential *OAuthCredential) error {
+		return errSave
+	}, func(err error) {
+		warnings = append(warnings, err)
+	})
+
+	// The refreshed token is still used
+	if _, err := client.FetchUsers(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(warnings) != 1 {
+		t.Fatalf("got %d warnings, want 1", len(warnings))
+	}
+	if !errors.Is(warnings[0], errSave) {
+		t.Errorf("warning %v does not wrap the save error", warnings[0])
+	}
+}
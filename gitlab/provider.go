@@ -0,0 +1,49 @@
+package gitlab
+
+import (
+	"context"
+
+	"github.com/deathrjj/age-gitlab-tool-tui/models"
+	"github.com/deathrjj/age-gitlab-tool-tui/provider"
+)
+
+var (
+	_ provider.Provider      = &Client{}
+	_ provider.SSHKeyFetcher = &Client{}
+)
+
+// Name implements provider.Provider
+func (c *Client) Name() string {
+	return Source
+}
+
+// ListUsers implements provider.Provider
+func (c *Client) ListUsers(ctx context.Context) ([]models.User, error) {
+	return c.FetchUsers(ctx)
+}
+
+// FetchKeys implements provider.Provider, returning the user's SSH keys and
+// the native age recipients in their bio
+func (c *Client) FetchKeys(ctx context.Context, user models.User) ([]models.Key, error) {
+	keys, err := c.FetchUserKeys(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	ageKeys, err := c.FetchUserAgeRecipients(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, key := range ageKeys {
+		keys = append(keys, models.Key{Key: key, Title: "age recipient in profile bio"})
+	}
+
+	return keys, nil
+}
+
+// FetchSSHKeys implements provider.SSHKeyFetcher, leaving out the request for
+// the user's bio
+func (c *Client) FetchSSHKeys(ctx context.Context, user models.User) ([]models.Key, error) {
+	return c.FetchUserKeys(ctx, user.ID)
+}
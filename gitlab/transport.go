@@ -0,0 +1,25 @@
+package gitlab
+
+import (
+	"net/http"
+
+	"github.com/deathrjj/age-gitlab-tool-tui/provider"
+)
+
+// InsecureWarning is shown whenever TLS certificates are not verified
+const InsecureWarning = "GITLAB_INSECURE_SKIP_VERIFY is set, the TLS certificate of GitLab is not verified. " +
+	"Anyone on the network can read the token and swap the keys that are encrypted to. Only use this for test instances."
+
+// NewHTTPClient returns the HTTP client for requests to GitLab, configured by
+// GITLAB_CA_FILE, GITLAB_CLIENT_CERT, GITLAB_CLIENT_KEY, GITLAB_PROXY,
+// GITLAB_TIMEOUT and GITLAB_INSECURE_SKIP_VERIFY as described at
+// provider.NewHTTPClient
+func NewHTTPClient() (*http.Client, error) {
+	return provider.NewHTTPClient("GITLAB")
+}
+
+// InsecureSkipVerify reports whether GITLAB_INSECURE_SKIP_VERIFY turns off the
+// verification of TLS certificates
+func InsecureSkipVerify() bool {
+	return provider.InsecureSkipVerify("GITLAB")
+}
@@ -4,12 +4,25 @@ import (
 	"fmt"
 	"os"
 
+	"github.com/deathrjj/age-gitlab-tool-tui/cli"
 	"github.com/deathrjj/age-gitlab-tool-tui/encryption"
 	"github.com/deathrjj/age-gitlab-tool-tui/ui"
 	"github.com/rivo/tview"
 )
 
 func main() {
+	// Settings come from the environment and the chosen configuration profile
+	args, err := cli.ApplyOptions(os.Args[1:])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "age-gitlab-tool-tui: error: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Subcommands run without the terminal UI so they can be used in scripts
+	if len(args) > 0 {
+		os.Exit(cli.Run(args))
+	}
+
 	// Check if demo mode is enabled
 	if os.Getenv("AGE_TOOL_DEMO_MODE") != "" {
 		fmt.Println("Running in demo mode - usernames will be partially censored")
@@ -29,4 +42,6 @@ func main() {
 	if err := app.Run(); err != nil {
 		panic(err)
 	}
+	ui.PrintOutput()
+	ui.PrintWarnings()
 }
@@ -0,0 +1,47 @@
+package models
+
+import "time"
+
+// Key is a public key of a user, either an SSH public key in authorized_keys
+// format or a native age recipient, with the details its source knows about
+type Key struct {
+	ID    int    `json:"id"`
+	Key   string `json:"key"`
+	Title string `json:"title"`
+	// CreatedAt and ExpiresAt are nil if unknown, or if the key does not expire
+	CreatedAt *time.Time `json:"created_at"`
+	ExpiresAt *time.Time `json:"expires_at"`
+	// UsageType is "auth", "signing" or "auth_and_signing" for GitLab keys,
+	// and empty if the source does not tell
+	UsageType string `json:"usage_type"`
+}
+
+// PlainKeys returns keys that come without any details as Keys
+func PlainKeys(keys []string) []Key {
+	plain := make([]Key, len(keys))
+	for i, key := range keys {
+		plain[i] = Key{Key: key}
+	}
+	return plain
+}
+
+// KeyExclusionMap stores the keys left out when encrypting to a user (map of
+// User.Key to the excluded keys)
+type KeyExclusionMap map[string]map[string]bool
+
+// Excluded reports whether a key of the user is excluded
+func (m KeyExclusionMap) Excluded(user User, key string) bool {
+	return m[user.Key()][key]
+}
+
+// Toggle excludes a key of the user, or includes it again
+func (m KeyExclusionMap) Toggle(user User, key string) {
+	if m[user.Key()] == nil {
+		m[user.Key()] = make(map[string]bool)
+	}
+	if m[user.Key()][key] {
+		delete(m[user.Key()], key)
+	} else {
+		m[user.Key()][key] = true
+	}
+}
@@ -1,10 +1,93 @@
 package models
 
-// User represents a GitLab user.
+import (
+	"fmt"
+	"strings"
+)
+
+// User represents a GitLab user, or a principal of another recipient source.
 type User struct {
 	ID       int    `json:"id"`
 	Username string `json:"username"`
+	// Source is the name of the provider the user comes from, such as "gitlab"
+	Source string `json:"-"`
+}
+
+// GitLabSource is the Source of GitLab users, whose usernames are shown
+// without it
+const GitLabSource = "gitlab"
+
+// Key uniquely identifies the user across all sources, as "source/username".
+func (u User) Key() string {
+	return u.Source + "/" + u.Username
+}
+
+// UserSelectionMap stores which users are selected (map of User.Key to selection status)
+type UserSelectionMap map[string]bool
+
+// Group represents a GitLab group.
+type Group struct {
+	ID       int    `json:"id"`
+	Name     string `json:"name"`
+	FullPath string `json:"full_path"`
+}
+
+// GroupSelectionMap stores which groups are selected (map of group ID to selection status)
+type GroupSelectionMap map[int]bool
+
+// Member represents a user's membership of a GitLab group or project.
+type Member struct {
+	ID          int         `json:"id"`
+	Username    string      `json:"username"`
+	State       string      `json:"state"`
+	AccessLevel AccessLevel `json:"access_level"`
 }
 
-// UserSelectionMap stores which users are selected (map of user ID to selection status)
-type UserSelectionMap map[int]bool 
+// Project represents a GitLab project.
+type Project struct {
+	ID                int    `json:"id"`
+	Name              string `json:"name"`
+	PathWithNamespace string `json:"path_with_namespace"`
+}
+
+// ProjectSelectionMap stores which projects are selected (map of project ID to
+// the minimum access level members need to be included)
+type ProjectSelectionMap map[int]AccessLevel
+
+// AccessLevel is the access level of a group or project member.
+type AccessLevel int
+
+// Access levels as defined by the GitLab API
+const (
+	GuestAccess      AccessLevel = 10
+	ReporterAccess   AccessLevel = 20
+	DeveloperAccess  AccessLevel = 30
+	MaintainerAccess AccessLevel = 40
+	OwnerAccess      AccessLevel = 50
+)
+
+var accessLevelNames = map[AccessLevel]string{
+	GuestAccess:      "Guest",
+	ReporterAccess:   "Reporter",
+	DeveloperAccess:  "Developer",
+	MaintainerAccess: "Maintainer",
+	OwnerAccess:      "Owner",
+}
+
+// String returns the name of the access level as shown by GitLab.
+func (a AccessLevel) String() string {
+	if name, ok := accessLevelNames[a]; ok {
+		return name
+	}
+	return fmt.Sprintf("AccessLevel(%d)", int(a))
+}
+
+// ParseAccessLevel parses an access level name such as "maintainer", ignoring case.
+func ParseAccessLevel(name string) (AccessLevel, error) {
+	for level, levelName := range accessLevelNames {
+		if strings.EqualFold(name, levelName) {
+			return level, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown access level %q, expected guest, reporter, developer, maintainer or owner", name)
+}
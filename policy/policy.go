@@ -0,0 +1,235 @@
+package policy
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/deathrjj/age-gitlab-tool-tui/encryption"
+	"github.com/deathrjj/age-gitlab-tool-tui/models"
+	"github.com/deathrjj/age-gitlab-tool-tui/provider"
+)
+
+// Policy restricts the keys that are encrypted to, as a security team may
+// require. The zero Policy allows every key.
+type Policy struct {
+	// KeyTypes are the allowed key types, as encryption.DescribeKey names
+	// them, such as "ed25519", "rsa" or "X25519". Empty allows all types.
+	KeyTypes []string
+	// MinRSABits is the minimum size of RSA keys
+	MinRSABits int
+	// MaxKeyAge is the maximum time since a key was created. Keys whose
+	// creation date is unknown are still encrypted to, but reported as
+	// unverified and not counted as compliant.
+	MaxKeyAge time.Duration
+	// MinKeys is the number of keys every user must have that can be
+	// encrypted to and comply with the policy
+	MinKeys int
+	// DeniedFingerprints are the SHA256 fingerprints of SSH keys, such as
+	// "SHA256:...", and native age recipients that must not be encrypted to
+	DeniedFingerprints []string
+}
+
+// Violation is a key that the policy forbids, and why
+type Violation struct {
+	Key     models.Key
+	Details encryption.KeyDetails
+	Reason  string
+}
+
+// String describes the violation, such as "rsa SHA256:... (laptop): smaller
+// than 3072 bits"
+func (v Violation) String() string {
+	text := v.Details.Type + " " + v.Details.Fingerprint
+	if v.Key.Title != "" {
+		text += " (" + v.Key.Title + ")"
+	}
+	return text + ": " + v.Reason
+}
+
+// Report lists how the keys of a user violate the policy
+type Report struct {
+	User       models.User
+	Violations []Violation
+	// Unverified are the keys that are encrypted to although the policy
+	// cannot be checked for them, such as keys without a creation date
+	Unverified []Violation
+	// Compliant is the number of keys left to encrypt to, and Required the
+	// number the policy requires
+	Compliant int
+	Required  int
+}
+
+// Rejected reports whether the user has too few compliant keys to be
+// encrypted to
+func (r Report) Rejected() bool {
+	return r.Compliant < r.Required
+}
+
+// String describes the violations, such as "gitlab/bob: rsa SHA256:...:
+// smaller than 3072 bits; 0 compliant keys, at least 1 required"
+func (r Report) String() string {
+	var parts []string
+	for _, violation := range r.Violations {
+		parts = append(parts, violation.String())
+	}
+	for _, violation := range r.Unverified {
+		parts = append(parts, violation.String())
+	}
+	if r.Rejected() {
+		parts = append(parts, fmt.Sprintf("%d compliant keys, at least %d required", r.Compliant, r.Required))
+	}
+	return r.User.Key() + ": " + strings.Join(parts, "; ")
+}
+
+// FromEnvironment returns the policy set by AGE_POLICY_KEY_TYPES and
+// AGE_POLICY_DENIED_FINGERPRINTS, lists separated by commas,
+// AGE_POLICY_MIN_RSA_BITS, AGE_POLICY_MAX_KEY_AGE, a duration such as "730d"
+// or "2y", and AGE_POLICY_MIN_KEYS
+func FromEnvironment() (Policy, error) {
+	var p Policy
+	var err error
+	p.KeyTypes = provider.SplitList(os.Getenv("AGE_POLICY_KEY_TYPES"))
+	p.DeniedFingerprints = provider.SplitList(os.Getenv("AGE_POLICY_DENIED_FINGERPRINTS"))
+	if p.MinRSABits, err = parseCount("AGE_POLICY_MIN_RSA_BITS"); err != nil {
+		return Policy{}, err
+	}
+	if p.MinKeys, err = parseCount("AGE_POLICY_MIN_KEYS"); err != nil {
+		return Policy{}, err
+	}
+	if value := os.Getenv("AGE_POLICY_MAX_KEY_AGE"); value != "" {
+		if p.MaxKeyAge, err = ParseAge(value); err != nil {
+			return Policy{}, fmt.Errorf("invalid AGE_POLICY_MAX_KEY_AGE %q, use a duration such as 730d or 2y", value)
+		}
+	}
+	return p, nil
+}
+
+// ParseAge parses a duration as time.ParseDuration does, and in addition a
+// number of days or years such as "90d" or "2y", where a year is 365 days
+func ParseAge(value string) (time.Duration, error) {
+	day := 24 * time.Hour
+	for suffix, unit := range map[string]time.Duration{"d": day, "y": 365 * day} {
+		if number, ok := strings.CutSuffix(value, suffix); ok {
+			n, err := strconv.Atoi(number)
+			if err != nil || n < 0 {
+				return 0, fmt.Errorf("invalid duration %q", value)
+			}
+			return time.Duration(n) * unit, nil
+		}
+	}
+	age, err := time.ParseDuration(value)
+	if err == nil && age < 0 {
+		err = fmt.Errorf("invalid duration %q", value)
+	}
+	return age, err
+}
+
+// Check returns why the policy forbids a key, or "" if it allows it
+func (p Policy) Check(key models.Key, details encryption.KeyDetails, now time.Time) string {
+	if len(p.KeyTypes) > 0 && !containsFold(p.KeyTypes, details.Type) {
+		return details.Type + " keys are not allowed"
+	}
+	if details.Type == "rsa" && details.Bits < p.MinRSABits {
+		return fmt.Sprintf("smaller than %d bits", p.MinRSABits)
+	}
+	if p.MaxKeyAge > 0 && key.CreatedAt != nil && now.Sub(*key.CreatedAt) > p.MaxKeyAge {
+		return fmt.Sprintf("created %s, more than %d days ago", key.CreatedAt.Format(time.DateOnly), int(p.MaxKeyAge.Hours()/24))
+	}
+	// Fingerprints are base64, so case matters
+	for _, fingerprint := range p.DeniedFingerprints {
+		if fingerprint == details.Fingerprint {
+			return "denied"
+		}
+	}
+	return ""
+}
+
+// Unverifiable returns why the policy cannot be checked for a key that it
+// otherwise allows, or "" if it can
+func (p Policy) Unverifiable(key models.Key) string {
+	if p.MaxKeyAge > 0 && key.CreatedAt == nil {
+		return fmt.Sprintf("creation date unknown, the maximum age of %d days cannot be checked", int(p.MaxKeyAge.Hours()/24))
+	}
+	return ""
+}
+
+// Apply returns the keys of every user that the policy allows, and a report
+// for every user who has keys it forbids, keys it cannot check or too few
+// compliant keys. Keys it cannot check are allowed, but not counted as
+// compliant. Keys that cannot be encrypted to anyway are left for
+// encryption.ParseUserKeys to report.
+func (p Policy) Apply(userKeys []encryption.UserKeys) ([]encryption.UserKeys, []Report) {
+	now := time.Now()
+	var allowed []encryption.UserKeys
+	var reports []Report
+	for _, uk := range userKeys {
+		report := Report{User: uk.User, Required: p.MinKeys}
+		var keys []models.Key
+		for _, key := range uk.Keys {
+			if encryption.SkipReason(key) != "" {
+				keys = append(keys, key)
+				continue
+			}
+			details, _ := encryption.DescribeKey(key.Key)
+			if reason := p.Check(key, details, now); reason != "" {
+				report.Violations = append(report.Violations, Violation{Key: key, Details: details, Reason: reason})
+				continue
+			}
+			keys = append(keys, key)
+			if reason := p.Unverifiable(key); reason != "" {
+				report.Unverified = append(report.Unverified, Violation{Key: key, Details: details, Reason: reason})
+				continue
+			}
+			report.Compliant++
+		}
+
+		allowed = append(allowed, encryption.UserKeys{User: uk.User, Keys: keys})
+		if len(report.Violations) > 0 || len(report.Unverified) > 0 || report.Rejected() {
+			reports = append(reports, report)
+		}
+	}
+
+	return allowed, reports
+}
+
+// Enforce returns an error listing the users who have too few compliant keys,
+// or nil if there are none
+func Enforce(reports []Report) error {
+	var rejected []string
+	for _, report := range reports {
+		if report.Rejected() {
+			rejected = append(rejected, report.String())
+		}
+	}
+	if len(rejected) == 0 {
+		return nil
+	}
+	return fmt.Errorf("the key policy forbids encrypting to %s", strings.Join(rejected, "; "))
+}
+
+// parseCount returns the non-negative number in the environment variable, or
+// 0 if it is not set
+func parseCount(variable string) (int, error) {
+	value := os.Getenv(variable)
+	if value == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid %s %q, use a number", variable, value)
+	}
+	return n, nil
+}
+
+// containsFold reports whether list contains item, ignoring case
+func containsFold(list []string, item string) bool {
+	for _, candidate := range list {
+		if strings.EqualFold(candidate, item) {
+			return true
+		}
+	}
+	return false
+}
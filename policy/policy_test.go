@@ -0,0 +1,259 @@
+package policy
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"filippo.io/age"
+	"github.com/deathrjj/age-gitlab-tool-tui/encryption"
+	"github.com/deathrjj/age-gitlab-tool-tui/models"
+	"golang.org/x/crypto/ssh"
+)
+
+// newKey returns the public key of a new ed25519 or RSA key as a models.Key
+// created at the given time, or without a creation date if zero
+func newKey(t *testing.T, rsaBits int, createdAt time.Time) models.Key {
+	t.Helper()
+	var public any
+	if rsaBits > 0 {
+		key, err := rsa.GenerateKey(rand.Reader, rsaBits)
+		if err != nil {
+			t.Fatal(err)
+		}
+		public = &key.PublicKey
+	} else {
+		key, _, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		public = key
+	}
+	publicKey, err := ssh.NewPublicKey(public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := models.Key{Key: string(ssh.MarshalAuthorizedKey(publicKey)), Title: "laptop"}
+	if !createdAt.IsZero() {
+		key.CreatedAt = &createdAt
+	}
+	return key
+}
+
+// describe returns the details of a key
+func describe(t *testing.T, key models.Key) encryption.KeyDetails {
+	t.Helper()
+	details, err := encryption.DescribeKey(key.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return details
+}
+
+func TestParseAge(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Duration
+		err   bool
+	}{
+		{"90d", 90 * 24 * time.Hour, false},
+		{"2y", 2 * 365 * 24 * time.Hour, false},
+		{"0d", 0, false},
+		{"36h", 36 * time.Hour, false},
+		{"1h30m", 90 * time.Minute, false},
+		{"-1d", 0, true},
+		{"-1h", 0, true},
+		{"d", 0, true},
+		{"1.5y", 0, true},
+		{"two years", 0, true},
+		{"", 0, true},
+	}
+	for _, test := range tests {
+		t.Run(test.value, func(t *testing.T) {
+			got, err := ParseAge(test.value)
+			if (err != nil) != test.err || (!test.err && got != test.want) {
+				t.Errorf("got %v and %v, want %v and an error: %v", got, err, test.want, test.err)
+			}
+		})
+	}
+}
+
+func TestFromEnvironment(t *testing.T) {
+	t.Setenv("AGE_POLICY_KEY_TYPES", "ed25519, rsa")
+	t.Setenv("AGE_POLICY_DENIED_FINGERPRINTS", "SHA256:a,SHA256:b")
+	t.Setenv("AGE_POLICY_MIN_RSA_BITS", "3072")
+	t.Setenv("AGE_POLICY_MAX_KEY_AGE", "2y")
+	t.Setenv("AGE_POLICY_MIN_KEYS", "1")
+
+	got, err := FromEnvironment()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Policy{
+		KeyTypes:           []string{"ed25519", "rsa"},
+		MinRSABits:         3072,
+		MaxKeyAge:          2 * 365 * 24 * time.Hour,
+		MinKeys:            1,
+		DeniedFingerprints: []string{"SHA256:a", "SHA256:b"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestFromEnvironmentUnset(t *testing.T) {
+	for _, variable := range []string{"AGE_POLICY_KEY_TYPES", "AGE_POLICY_DENIED_FINGERPRINTS", "AGE_POLICY_MIN_RSA_BITS", "AGE_POLICY_MAX_KEY_AGE", "AGE_POLICY_MIN_KEYS"} {
+		t.Setenv(variable, "")
+	}
+	got, err := FromEnvironment()
+	if err != nil || !reflect.DeepEqual(got, Policy{}) {
+		t.Errorf("got %+v and %v, want the zero policy", got, err)
+	}
+}
+
+func TestFromEnvironmentInvalid(t *testing.T) {
+	tests := []struct {
+		variable string
+		value    string
+	}{
+		{"AGE_POLICY_MIN_RSA_BITS", "many"},
+		{"AGE_POLICY_MIN_RSA_BITS", "-1"},
+		{"AGE_POLICY_MIN_KEYS", "one"},
+		{"AGE_POLICY_MIN_KEYS", "-2"},
+		{"AGE_POLICY_MAX_KEY_AGE", "forever"},
+		{"AGE_POLICY_MAX_KEY_AGE", "-2y"},
+	}
+	for _, test := range tests {
+		t.Run(test.variable+"="+test.value, func(t *testing.T) {
+			t.Setenv(test.variable, test.value)
+			_, err := FromEnvironment()
+			if err == nil || !strings.Contains(err.Error(), test.variable) {
+				t.Errorf("got %v, want an error naming %s", err, test.variable)
+			}
+		})
+	}
+}
+
+func TestCheck(t *testing.T) {
+	now := time.Now()
+	ed25519Key := newKey(t, 0, now.AddDate(-1, 0, 0))
+	rsaKey := newKey(t, 2048, now.AddDate(0, -1, 0))
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	nativeKey := models.Key{Key: identity.Recipient().String()}
+
+	tests := []struct {
+		name   string
+		policy Policy
+		key    models.Key
+		want   string
+	}{
+		{"zero policy", Policy{}, rsaKey, ""},
+		{"allowed type", Policy{KeyTypes: []string{"ED25519"}}, ed25519Key, ""},
+		{"forbidden type", Policy{KeyTypes: []string{"ed25519"}}, rsaKey, "rsa keys are not allowed"},
+		{"forbidden native type", Policy{KeyTypes: []string{"ed25519"}}, nativeKey, "X25519 keys are not allowed"},
+		{"small RSA key", Policy{MinRSABits: 3072}, rsaKey, "smaller than 3072 bits"},
+		{"large RSA key", Policy{MinRSABits: 2048}, rsaKey, ""},
+		{"RSA size of other types", Policy{MinRSABits: 3072}, ed25519Key, ""},
+		{"old key", Policy{MaxKeyAge: 180 * 24 * time.Hour}, ed25519Key, "created " + ed25519Key.CreatedAt.Format(time.DateOnly) + ", more than 180 days ago"},
+		{"recent key", Policy{MaxKeyAge: 180 * 24 * time.Hour}, rsaKey, ""},
+		{"denied fingerprint", Policy{DeniedFingerprints: []string{describe(t, rsaKey).Fingerprint}}, rsaKey, "denied"},
+		{"denied fingerprint case", Policy{DeniedFingerprints: []string{strings.ToLower(describe(t, rsaKey).Fingerprint)}}, rsaKey, ""},
+		{"denied native recipient", Policy{DeniedFingerprints: []string{nativeKey.Key}}, nativeKey, "denied"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.policy.Check(test.key, describe(t, test.key), now); got != test.want {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestUnverifiable(t *testing.T) {
+	dated := newKey(t, 0, time.Now())
+	undated := newKey(t, 0, time.Time{})
+
+	p := Policy{MaxKeyAge: 730 * 24 * time.Hour}
+	if got := p.Unverifiable(dated); got != "" {
+		t.Errorf("got %q for a key with a creation date", got)
+	}
+	if got := p.Unverifiable(undated); !strings.Contains(got, "730 days") {
+		t.Errorf("got %q for a key without a creation date", got)
+	}
+	if got := (Policy{}).Unverifiable(undated); got != "" {
+		t.Errorf("got %q without a maximum age", got)
+	}
+}
+
+func TestApply(t *testing.T) {
+	now := time.Now()
+	recent := newKey(t, 0, now)
+	old := newKey(t, 0, now.AddDate(-3, 0, 0))
+	undated := newKey(t, 0, time.Time{})
+	invalid := models.Key{Key: "ssh-ed25519 broken"}
+	alice := models.User{Username: "alice", Source: "gitlab"}
+	bob := models.User{Username: "bob", Source: "github"}
+	carol := models.User{Username: "carol", Source: "gitlab"}
+
+	p := Policy{MaxKeyAge: 2 * 365 * 24 * time.Hour, MinKeys: 1}
+	allowed, reports := p.Apply([]encryption.UserKeys{
+		{User: alice, Keys: []models.Key{recent, old, invalid}},
+		{User: bob, Keys: []models.Key{undated}},
+		{User: carol, Keys: []models.Key{recent}},
+	})
+
+	// Forbidden keys are left out, and invalid and unverified keys kept
+	want := []encryption.UserKeys{
+		{User: alice, Keys: []models.Key{recent, invalid}},
+		{User: bob, Keys: []models.Key{undated}},
+		{User: carol, Keys: []models.Key{recent}},
+	}
+	if !reflect.DeepEqual(allowed, want) {
+		t.Errorf("got allowed keys %+v, want %+v", allowed, want)
+	}
+
+	if len(reports) != 2 {
+		t.Fatalf("got %d reports, want alice's and bob's", len(reports))
+	}
+	if r := reports[0]; r.User != alice || len(r.Violations) != 1 || r.Violations[0].Key != old || r.Compliant != 1 || r.Rejected() {
+		t.Errorf("got report %+v, want alice's old key", r)
+	}
+	// Keys without a creation date do not count as compliant
+	if r := reports[1]; r.User != bob || len(r.Unverified) != 1 || r.Compliant != 0 || !r.Rejected() {
+		t.Errorf("got report %+v, want bob's key unverified and bob rejected", r)
+	}
+	if s := reports[1].String(); !strings.Contains(s, "github/bob") || !strings.Contains(s, "creation date unknown") || !strings.Contains(s, "0 compliant keys, at least 1 required") {
+		t.Errorf("got report %q", s)
+	}
+}
+
+func TestApplyZeroPolicy(t *testing.T) {
+	userKeys := []encryption.UserKeys{{User: models.User{Username: "alice"}, Keys: []models.Key{newKey(t, 0, time.Time{})}}}
+	allowed, reports := Policy{}.Apply(userKeys)
+	if !reflect.DeepEqual(allowed, userKeys) || len(reports) != 0 {
+		t.Errorf("got %+v and reports %+v, want every key and no reports", allowed, reports)
+	}
+}
+
+func TestEnforce(t *testing.T) {
+	warned := Report{User: models.User{Username: "alice", Source: "gitlab"}, Violations: []Violation{{Reason: "denied"}}, Compliant: 1, Required: 1}
+	rejected := Report{User: models.User{Username: "bob", Source: "gitlab"}, Compliant: 0, Required: 2}
+
+	if err := Enforce(nil); err != nil {
+		t.Errorf("got %v without reports", err)
+	}
+	if err := Enforce([]Report{warned}); err != nil {
+		t.Errorf("got %v for a user with enough keys", err)
+	}
+	err := Enforce([]Report{warned, rejected})
+	if err == nil || strings.Contains(err.Error(), "alice") || !strings.Contains(err.Error(), "gitlab/bob: 0 compliant keys, at least 2 required") {
+		t.Errorf("got %v, want an error naming bob only", err)
+	}
+}
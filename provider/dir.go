@@ -0,0 +1,126 @@
+package provider
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/deathrjj/age-gitlab-tool-tui/models"
+	"golang.org/x/crypto/ssh"
+)
+
+// keyFileExtensions are stripped from file names to get the user name
+var keyFileExtensions = []string{".pub", ".keys", ".txt"}
+
+// Dir is a provider reading users from a directory of authorized_keys files.
+// Every file is a user named after the file, without a .pub, .keys or .txt
+// extension, and holds that user's SSH public keys in authorized_keys format.
+// Native age recipients may be listed on their own lines as well.
+type Dir struct {
+	path string
+}
+
+var _ Provider = &Dir{}
+
+// NewDir returns a provider for the authorized_keys directory at path
+func NewDir(path string) *Dir {
+	return &Dir{path: path}
+}
+
+// Name implements Provider
+func (d *Dir) Name() string {
+	return "dir:" + filepath.Base(filepath.Clean(d.path))
+}
+
+// ListUsers implements Provider
+func (d *Dir) ListUsers(ctx context.Context) ([]models.User, error) {
+	entries, err := os.ReadDir(d.path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read keys directory: %w", err)
+	}
+
+	var users []models.User
+	seen := make(map[string]bool)
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		name := userName(entry.Name())
+		if !seen[name] {
+			seen[name] = true
+			users = append(users, models.User{Username: name, Source: d.Name()})
+		}
+	}
+	return users, nil
+}
+
+// FetchKeys implements Provider
+func (d *Dir) FetchKeys(ctx context.Context, user models.User) ([]models.Key, error) {
+	entries, err := os.ReadDir(d.path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read keys directory: %w", err)
+	}
+
+	var keys []models.Key
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() || userName(entry.Name()) != user.Username {
+			continue
+		}
+		fileKeys, err := readAuthorizedKeys(filepath.Join(d.path, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range fileKeys {
+			keys = append(keys, models.Key{Key: key, Title: entry.Name()})
+		}
+	}
+	return keys, nil
+}
+
+// userName returns the user a key file belongs to
+func userName(fileName string) string {
+	for _, ext := range keyFileExtensions {
+		if strings.HasSuffix(fileName, ext) {
+			return strings.TrimSuffix(fileName, ext)
+		}
+	}
+	return fileName
+}
+
+// readAuthorizedKeys reads the keys of an authorized_keys file, dropping any
+// options so only the key type, key and comment remain
+func readAuthorizedKeys(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key file: %w", err)
+	}
+
+	var keys []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "age1") {
+			keys = append(keys, line)
+			continue
+		}
+
+		key, comment, _, _, err := ssh.ParseAuthorizedKey([]byte(line))
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, lineNumber, err)
+		}
+		authorizedKey := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key)))
+		if comment != "" {
+			authorizedKey += " " + comment
+		}
+		keys = append(keys, authorizedKey)
+	}
+
+	return keys, scanner.Err()
+}
@@ -0,0 +1,89 @@
+package provider
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Errors that an *APIError matches with errors.Is, by status code
+var (
+	ErrUnauthorized = errors.New("unauthorized")
+	ErrForbidden    = errors.New("forbidden")
+	ErrNotFound     = errors.New("not found")
+	ErrRateLimited  = errors.New("rate limited")
+)
+
+// APIError is an error response of the API of a provider
+type APIError struct {
+	// Source is the name of the provider, such as "github"
+	Source string
+	// Path is the path of the request, such as "/orgs/acme/members"
+	Path       string
+	StatusCode int
+	// Status is the status line, such as "403 Forbidden"
+	Status string
+	// Message is the message the server sent, if any
+	Message string
+	// RateLimited reports whether the rate limit is exhausted, which GitHub
+	// answers with 403 Forbidden rather than 429 Too Many Requests
+	RateLimited bool
+	// RetryAfter is how long to wait before retrying a rate limited request,
+	// if the server said so
+	RetryAfter time.Duration
+}
+
+// NewAPIError decodes an error response of the request for endpoint, whose
+// body is JSON such as {"message": "Not Found"}, or plain text
+func NewAPIError(source, endpoint string, statusCode int, status string, header http.Header, body []byte) *APIError {
+	e := &APIError{Source: source, Path: endpoint, StatusCode: statusCode, Status: status}
+	if u, err := url.Parse(endpoint); err == nil {
+		e.Path = u.Path
+	}
+	if e.Status == "" {
+		e.Status = fmt.Sprintf("%d %s", statusCode, http.StatusText(statusCode))
+	}
+
+	var response struct {
+		Message string `json:"message"`
+	}
+	if err := json.Unmarshal(body, &response); err == nil {
+		e.Message = response.Message
+	}
+	if RateLimited(statusCode, header) {
+		e.RateLimited = true
+		e.RetryAfter = RetryDelay(header, 0)
+	}
+
+	return e
+}
+
+// Error returns the path, status and message, such as "GET
+// /orgs/acme/members: 404 Not Found"
+func (e *APIError) Error() string {
+	text := fmt.Sprintf("GET %s: %s", e.Path, e.Status)
+	if e.Message != "" && !strings.EqualFold(e.Message, e.Status) &&
+		!strings.EqualFold(e.Message, http.StatusText(e.StatusCode)) {
+		text += ": " + e.Message
+	}
+	return text
+}
+
+// Unwrap returns the error for the status code, such as ErrNotFound, or nil
+func (e *APIError) Unwrap() error {
+	switch {
+	case e.RateLimited:
+		return ErrRateLimited
+	case e.StatusCode == http.StatusUnauthorized:
+		return ErrUnauthorized
+	case e.StatusCode == http.StatusForbidden:
+		return ErrForbidden
+	case e.StatusCode == http.StatusNotFound:
+		return ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package provider
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/deathrjj/age-gitlab-tool-tui/models"
+)
+
+// File is a provider reading users from a static recipients file. Every line
+// holds a name followed by one of its SSH public keys or age recipients:
+//
+//	# Comments and blank lines are ignored
+//	alice ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAA... alice@laptop
+//	alice age1ql3z7hjy54pw3hyww5ayyfg7zqgvc7w3j2elw8zmrj2kg5sfn9aqmcac8p
+//	bob ssh-rsa AAAAB3NzaC1yc2EAAAADAQABAAABAQ...
+type File struct {
+	path string
+}
+
+var _ Provider = &File{}
+
+// NewFile returns a provider for the recipients file at path
+func NewFile(path string) *File {
+	return &File{path: path}
+}
+
+// Name implements Provider
+func (f *File) Name() string {
+	return "file:" + filepath.Base(f.path)
+}
+
+// ListUsers implements Provider
+func (f *File) ListUsers(ctx context.Context) ([]models.User, error) {
+	keys, names, err := f.read()
+	if err != nil {
+		return nil, err
+	}
+
+	users := make([]models.User, 0, len(keys))
+	for _, name := range names {
+		users = append(users, models.User{Username: name, Source: f.Name()})
+	}
+	return users, nil
+}
+
+// FetchKeys implements Provider
+func (f *File) FetchKeys(ctx context.Context, user models.User) ([]models.Key, error) {
+	keys, _, err := f.read()
+	if err != nil {
+		return nil, err
+	}
+	return models.PlainKeys(keys[user.Username]), nil
+}
+
+// read parses the recipients file into the keys of every name, and the names
+// in the order they first appear
+func (f *File) read() (map[string][]string, []string, error) {
+	file, err := os.Open(f.path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open recipients file: %w", err)
+	}
+	defer file.Close()
+
+	keys := make(map[string][]string)
+	var names []string
+	scanner := bufio.NewScanner(file)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		name := strings.Fields(line)[0]
+		key := strings.TrimSpace(strings.TrimPrefix(line, name))
+		if key == "" {
+			return nil, nil, fmt.Errorf("%s:%d: expected a name followed by a key", f.path, lineNumber)
+		}
+
+		if _, seen := keys[name]; !seen {
+			names = append(names, name)
+		}
+		keys[name] = append(keys[name], key)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, fmt.Errorf("failed to read recipients file: %w", err)
+	}
+
+	return keys, names, nil
+}
@@ -0,0 +1,39 @@
+package provider
+
+import (
+	"context"
+)
+
+// FetchPages retrieves every page of a paginated list with fetchPage, which
+// is called with the page number starting at 1, and concatenates them. Pages
+// hold perPage items, except for the last one. After the first page,
+// Concurrency pages are fetched at once.
+func FetchPages[T any](ctx context.Context, perPage int, fetchPage func(ctx context.Context, page int) ([]T, error)) ([]T, error) {
+	all, err := fetchPage(ctx, 1)
+	if err != nil || len(all) < perPage {
+		return all, err
+	}
+
+	window, err := Concurrency()
+	if err != nil {
+		return nil, err
+	}
+	for next := 2; ; next += window {
+		pages := make([][]T, window)
+		err := Parallel(ctx, window, func(ctx context.Context, i int) error {
+			items, err := fetchPage(ctx, next+i)
+			pages[i] = items
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, items := range pages {
+			all = append(all, items...)
+			if len(items) < perPage {
+				return all, nil
+			}
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+)
+
+// DefaultConcurrency is how many requests are sent at once, unless
+// AGE_FETCH_CONCURRENCY says otherwise
+const DefaultConcurrency = 8
+
+// Concurrency returns how many requests may be sent at once, from
+// AGE_FETCH_CONCURRENCY
+func Concurrency() (int, error) {
+	value := os.Getenv("AGE_FETCH_CONCURRENCY")
+	if value == "" {
+		return DefaultConcurrency, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("invalid AGE_FETCH_CONCURRENCY %q, use a positive number", value)
+	}
+	return n, nil
+}
+
+// Parallel calls fetch for every index from 0 to count-1, running at most
+// Concurrency calls at once. The first error cancels the context passed to
+// the other calls and is returned once all have finished.
+func Parallel(ctx context.Context, count int, fetch func(ctx context.Context, i int) error) error {
+	concurrency, err := Concurrency()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg       sync.WaitGroup
+		once     sync.Once
+		firstErr error
+	)
+	slots := make(chan struct{}, concurrency)
+	for i := 0; i < count; i++ {
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			if err := fetch(ctx, i); err != nil {
+				once.Do(func() {
+					firstErr = err
+					cancel()
+				})
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return firstErr
+	}
+	return ctx.Err()
+}
@@ -0,0 +1,93 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/deathrjj/age-gitlab-tool-tui/models"
+)
+
+// Provider is a source of users and the public keys to encrypt to them
+type Provider interface {
+	// Name identifies the provider and is set as the Source of its users
+	Name() string
+	// ListUsers returns the users that can be selected as recipients
+	ListUsers(ctx context.Context) ([]models.User, error)
+	// FetchKeys returns the SSH public keys and native age recipients of a
+	// user, giving up once ctx is done
+	FetchKeys(ctx context.Context, user models.User) ([]models.Key, error)
+}
+
+// SSHKeyFetcher is implemented by providers whose FetchKeys needs additional
+// requests for native age recipients, to fetch only the SSH keys of a user
+type SSHKeyFetcher interface {
+	FetchSSHKeys(ctx context.Context, user models.User) ([]models.Key, error)
+}
+
+// Set combines several providers into a single list of users
+type Set []Provider
+
+// Get returns the provider with the given name
+func (s Set) Get(name string) (Provider, bool) {
+	for _, p := range s {
+		if p.Name() == name {
+			return p, true
+		}
+	}
+	return nil, false
+}
+
+// ListUsers returns the users of all providers, sorted by username ignoring case
+func (s Set) ListUsers(ctx context.Context) ([]models.User, error) {
+	var users []models.User
+	for _, p := range s {
+		providerUsers, err := p.ListUsers(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", p.Name(), err)
+		}
+		users = append(users, providerUsers...)
+	}
+
+	sort.SliceStable(users, func(i, j int) bool {
+		return strings.ToLower(users[i].Username) < strings.ToLower(users[j].Username)
+	})
+
+	return users, nil
+}
+
+// FetchKeys fetches the keys of a user from the provider the user comes from
+func (s Set) FetchKeys(ctx context.Context, user models.User) ([]models.Key, error) {
+	p, ok := s.Get(user.Source)
+	if !ok {
+		return nil, fmt.Errorf("unknown recipient source %q for user %s", user.Source, user.Username)
+	}
+	return p.FetchKeys(ctx, user)
+}
+
+// FetchSSHKeys fetches the SSH keys of a user from the provider the user comes
+// from. Providers that are not SSHKeyFetchers return native age recipients
+// along with them.
+func (s Set) FetchSSHKeys(ctx context.Context, user models.User) ([]models.Key, error) {
+	p, ok := s.Get(user.Source)
+	if !ok {
+		return nil, fmt.Errorf("unknown recipient source %q for user %s", user.Source, user.Username)
+	}
+	if fetcher, ok := p.(SSHKeyFetcher); ok {
+		return fetcher.FetchSSHKeys(ctx, user)
+	}
+	return p.FetchKeys(ctx, user)
+}
+
+// SplitList splits a comma separated list, as in environment variables such
+// as GITHUB_USERS, dropping empty entries
+func SplitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,150 @@
+package provider
+
+import (
+	"context"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// maxRetries is how often a rate limited request is retried
+	maxRetries = 5
+	// maxRetryDelay is the longest wait before retrying. If the server asks
+	// for more, its response is returned instead.
+	maxRetryDelay = time.Minute
+)
+
+// RateLimiter sends the requests of a client, holding all of them back while
+// the rate limit of the server is exhausted, so parallel requests do not all
+// run into it. The zero value is ready to use.
+type RateLimiter struct {
+	mu       sync.Mutex
+	resumeAt time.Time
+}
+
+// wait blocks until requests may be sent again or ctx is done
+func (l *RateLimiter) wait(ctx context.Context) error {
+	l.mu.Lock()
+	delay := time.Until(l.resumeAt)
+	l.mu.Unlock()
+	return sleep(ctx, delay)
+}
+
+// pause holds back requests until t
+func (l *RateLimiter) pause(t time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if t.After(l.resumeAt) {
+		l.resumeAt = t
+	}
+}
+
+// update pauses requests until the rate limit resets if a response reports
+// that no requests are left
+func (l *RateLimiter) update(header http.Header) {
+	if remaining(header) != "0" {
+		return
+	}
+	if reset, err := strconv.ParseInt(rateLimitHeader(header, "Reset"), 10, 64); err == nil {
+		if resumeAt := time.Unix(reset, 0); time.Until(resumeAt) <= maxRetryDelay {
+			l.pause(resumeAt)
+		}
+	}
+}
+
+// Do sends a request with client, waiting for the rate limit to reset first
+// if needed. Responses with 429 Too Many Requests or 503 Service Unavailable,
+// and 403 Forbidden responses of an exhausted rate limit as GitHub sends
+// them, are retried after the delay the server asks for in Retry-After or
+// RateLimit-Reset, or with exponential backoff.
+func (l *RateLimiter) Do(client *http.Client, req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	for attempt := 0; ; attempt++ {
+		if err := l.wait(ctx); err != nil {
+			return nil, err
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		l.update(resp.Header)
+		if !RateLimited(resp.StatusCode, resp.Header) && resp.StatusCode != http.StatusServiceUnavailable {
+			return resp, nil
+		}
+
+		delay := RetryDelay(resp.Header, attempt)
+		if attempt >= maxRetries || delay > maxRetryDelay {
+			return resp, nil
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		l.pause(time.Now().Add(delay))
+	}
+}
+
+// RateLimited reports whether a response says that the rate limit is
+// exhausted: 429 Too Many Requests, or 403 Forbidden with no requests left
+// or a Retry-After header
+func RateLimited(statusCode int, header http.Header) bool {
+	switch statusCode {
+	case http.StatusTooManyRequests:
+		return true
+	case http.StatusForbidden:
+		return remaining(header) == "0" || header.Get("Retry-After") != ""
+	}
+	return false
+}
+
+// RetryDelay returns how long to wait before retrying a rate limited request
+func RetryDelay(header http.Header, attempt int) time.Duration {
+	if value := header.Get("Retry-After"); value != "" {
+		if seconds, err := strconv.Atoi(value); err == nil {
+			return time.Duration(seconds) * time.Second
+		}
+		if t, err := http.ParseTime(value); err == nil {
+			return time.Until(t)
+		}
+	}
+	if reset, err := strconv.ParseInt(rateLimitHeader(header, "Reset"), 10, 64); err == nil {
+		return time.Until(time.Unix(reset, 0))
+	}
+
+	// Back off exponentially from one second, with jitter so parallel
+	// requests do not retry all at once
+	backoff := time.Second << attempt
+	return backoff + rand.N(backoff/2)
+}
+
+// remaining returns how many requests the rate limit has left, or "" if the
+// server does not say
+func remaining(header http.Header) string {
+	return rateLimitHeader(header, "Remaining")
+}
+
+// rateLimitHeader returns a rate limit header such as RateLimit-Reset, as
+// GitLab sends it, or X-RateLimit-Reset, as GitHub and Gitea send it
+func rateLimitHeader(header http.Header, name string) string {
+	if value := header.Get("RateLimit-" + name); value != "" {
+		return value
+	}
+	return header.Get("X-RateLimit-" + name)
+}
+
+// sleep waits for delay or until ctx is done
+func sleep(ctx context.Context, delay time.Duration) error {
+	if delay <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
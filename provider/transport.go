@@ -0,0 +1,115 @@
+package provider
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+)
+
+// DefaultTimeout is how long a request may take, unless <PREFIX>_TIMEOUT
+// says otherwise
+const DefaultTimeout = 10 * time.Second
+
+// NewHTTPClient returns the HTTP client for requests to a provider, configured
+// by the environment variables starting with prefix, such as GITLAB:
+//   - <PREFIX>_CA_FILE: PEM file with CA certificates to trust besides the
+//     system ones, for instances with certificates of an internal CA
+//   - <PREFIX>_CLIENT_CERT and <PREFIX>_CLIENT_KEY: PEM files with the client
+//     certificate and its key for mutual TLS. The key may be in the
+//     certificate file, then <PREFIX>_CLIENT_KEY is not needed.
+//   - <PREFIX>_PROXY: URL of the proxy to use. If not set, HTTPS_PROXY,
+//     HTTP_PROXY and NO_PROXY are used.
+//   - <PREFIX>_TIMEOUT: how long a request may take, such as "30s", or 0 for
+//     no limit (default 10s)
+//   - <PREFIX>_INSECURE_SKIP_VERIFY: if true, TLS certificates are not
+//     verified at all
+func NewHTTPClient(prefix string) (*http.Client, error) {
+	timeout := DefaultTimeout
+	if value := os.Getenv(prefix + "_TIMEOUT"); value != "" {
+		var err error
+		timeout, err = time.ParseDuration(value)
+		if err != nil || timeout < 0 {
+			return nil, fmt.Errorf("invalid %s_TIMEOUT %q, use a duration such as 30s", prefix, value)
+		}
+	}
+
+	tlsConfig, err := tlsConfig(prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	proxy := http.ProxyFromEnvironment
+	if value := os.Getenv(prefix + "_PROXY"); value != "" {
+		proxyURL, err := url.Parse(value)
+		if err != nil || proxyURL.Host == "" {
+			return nil, fmt.Errorf("invalid %s_PROXY %q, use a URL such as http://proxy.example.com:3128", prefix, value)
+		}
+		proxy = http.ProxyURL(proxyURL)
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	transport.Proxy = proxy
+
+	return &http.Client{Transport: transport, Timeout: timeout}, nil
+}
+
+// InsecureSkipVerify reports whether <PREFIX>_INSECURE_SKIP_VERIFY turns off
+// the verification of TLS certificates
+func InsecureSkipVerify(prefix string) bool {
+	insecure, _ := strconv.ParseBool(os.Getenv(prefix + "_INSECURE_SKIP_VERIFY"))
+	return insecure
+}
+
+// tlsConfig returns the TLS configuration for <PREFIX>_CA_FILE,
+// <PREFIX>_CLIENT_CERT, <PREFIX>_CLIENT_KEY and <PREFIX>_INSECURE_SKIP_VERIFY
+func tlsConfig(prefix string) (*tls.Config, error) {
+	config := &tls.Config{}
+
+	if value := os.Getenv(prefix + "_INSECURE_SKIP_VERIFY"); value != "" {
+		insecure, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s_INSECURE_SKIP_VERIFY %q, use true or false", prefix, value)
+		}
+		config.InsecureSkipVerify = insecure
+	}
+
+	if path := os.Getenv(prefix + "_CA_FILE"); path != "" {
+		pem, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s_CA_FILE: %w", prefix, err)
+		}
+		// The system CAs are kept, for a proxy or redirects to other hosts
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no PEM certificates found in %s_CA_FILE %s", prefix, path)
+		}
+		config.RootCAs = pool
+	}
+
+	certFile := os.Getenv(prefix + "_CLIENT_CERT")
+	keyFile := os.Getenv(prefix + "_CLIENT_KEY")
+	switch {
+	case certFile == "" && keyFile != "":
+		return nil, fmt.Errorf("%s_CLIENT_KEY is set without %s_CLIENT_CERT", prefix, prefix)
+	case certFile != "":
+		if keyFile == "" {
+			keyFile = certFile
+		}
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load the client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	return config, nil
+}
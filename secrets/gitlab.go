@@ -0,0 +1,34 @@
+package secrets
+
+import (
+	"errors"
+	"os"
+
+	"github.com/deathrjj/age-gitlab-tool-tui/encryption"
+	"github.com/deathrjj/age-gitlab-tool-tui/gitlab"
+)
+
+// NewGitLabClient creates the GitLab client for GITLAB_URL from what is stored
+// for a profile: the token, which is applied to GITLAB_TOKEN, or else the
+// OAuth credential of a login, which is kept up to date as it is refreshed.
+// It returns ErrNotFound if neither is stored. warn is called if a refreshed
+// OAuth credential cannot be stored.
+func NewGitLabClient(profile string, passphrase encryption.PassphraseFunc, warn func(error)) (*gitlab.Client, error) {
+	token, _, err := LoadToken(profile, passphrase)
+	if err == nil {
+		os.Setenv("GITLAB_TOKEN", token)
+		return gitlab.NewClient()
+	}
+	if !errors.Is(err, ErrNotFound) {
+		return nil, err
+	}
+
+	credential, _, err := LoadOAuthToken(profile, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	return gitlab.NewOAuthClient(os.Getenv("GITLAB_URL"), credential, func(credential *gitlab.OAuthCredential) error {
+		_, err := SaveOAuthToken(profile, credential)
+		return err
+	}, warn)
+}
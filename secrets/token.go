@@ -0,0 +1,216 @@
+package secrets
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"filippo.io/age"
+	"github.com/deathrjj/age-gitlab-tool-tui/config"
+	"github.com/deathrjj/age-gitlab-tool-tui/encryption"
+	"github.com/deathrjj/age-gitlab-tool-tui/gitlab"
+	"github.com/zalando/go-keyring"
+)
+
+// keyringService is the service name tokens are stored under in the keyring
+const keyringService = "age-gitlab-tool"
+
+// ErrNotFound is returned if no token is stored for a profile
+var ErrNotFound = errors.New("no GitLab token stored")
+
+// Keyring and File describe where a token is stored
+const (
+	Keyring = "system keyring"
+	File    = "encrypted token file"
+)
+
+// SaveToken stores the GitLab token of a profile in the system keyring, such
+// as the Secret Service on Linux. Where no keyring is available, the token is
+// written to a file encrypted with age to the user's own SSH key or age
+// identity instead. It returns where the token was stored.
+func SaveToken(profile, token string) (string, error) {
+	return save(profile, token)
+}
+
+// LoadToken returns the stored GitLab token of a profile and where it was
+// stored. Unlocking a token file may ask for the passphrase of the key it is
+// encrypted to.
+func LoadToken(profile string, passphrase encryption.PassphraseFunc) (string, string, error) {
+	return load(profile, passphrase)
+}
+
+// SaveOAuthToken stores the OAuth credential of a profile obtained by logging
+// in, like SaveToken
+func SaveOAuthToken(profile string, credential *gitlab.OAuthCredential) (string, error) {
+	data, err := json.Marshal(credential)
+	if err != nil {
+		return "", err
+	}
+	return save(oauthAccount(profile), string(data))
+}
+
+// LoadOAuthToken returns the stored OAuth credential of a profile and where it
+// was stored, like LoadToken
+func LoadOAuthToken(profile string, passphrase encryption.PassphraseFunc) (*gitlab.OAuthCredential, string, error) {
+	data, where, err := load(oauthAccount(profile), passphrase)
+	if err != nil {
+		return nil, "", err
+	}
+
+	credential := &gitlab.OAuthCredential{}
+	if err := json.Unmarshal([]byte(data), credential); err != nil || credential.Token == nil {
+		return nil, "", fmt.Errorf("the stored OAuth token of profile %q is invalid, log in again", profile)
+	}
+	return credential, where, nil
+}
+
+// DeleteToken removes the stored GitLab token and OAuth credential of a
+// profile from the keyring and the token files
+func DeleteToken(profile string) error {
+	deleted := false
+	for _, account := range []string{profile, oauthAccount(profile)} {
+		if keyring.Delete(keyringService, account) == nil {
+			deleted = true
+		}
+
+		path, err := tokenFile(account)
+		if err != nil {
+			return err
+		}
+		if err := os.Remove(path); err == nil {
+			deleted = true
+		} else if !os.IsNotExist(err) {
+			return fmt.Errorf("failed to delete token file: %w", err)
+		}
+	}
+
+	if !deleted {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Mask hides all but the first and last few characters of a token
+func Mask(token string) string {
+	if len(token) <= 8 {
+		return strings.Repeat("*", len(token))
+	}
+	return token[:4] + strings.Repeat("*", len(token)-8) + token[len(token)-4:]
+}
+
+// oauthAccount returns the name the OAuth credential of a profile is stored
+// under, in the keyring and as token file
+func oauthAccount(profile string) string {
+	return profile + ".oauth"
+}
+
+// save stores a secret under the given account in the keyring, or in the
+// account's token file if no keyring is available
+func save(account, secret string) (string, error) {
+	if err := keyring.Set(keyringService, account, secret); err == nil {
+		// A token file left from before the keyring was available is stale now
+		if path, err := tokenFile(account); err == nil {
+			os.Remove(path)
+		}
+		return Keyring, nil
+	}
+
+	if err := saveTokenFile(account, secret); err != nil {
+		return "", err
+	}
+	return File, nil
+}
+
+// load returns the secret stored under the given account and where it was
+// stored
+func load(account string, passphrase encryption.PassphraseFunc) (string, string, error) {
+	if secret, err := keyring.Get(keyringService, account); err == nil {
+		return secret, Keyring, nil
+	}
+
+	secret, err := loadTokenFile(account, passphrase)
+	if err != nil {
+		return "", "", err
+	}
+	return secret, File, nil
+}
+
+// tokenFile returns the path of the encrypted token file of an account, next
+// to the configuration file
+func tokenFile(account string) (string, error) {
+	configPath, err := config.Path()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(filepath.Dir(configPath), "tokens", account+".age"), nil
+}
+
+// saveTokenFile encrypts the token to the first of the user's identities
+// that has a known public key
+func saveTokenFile(account, token string) error {
+	var recipient age.Recipient
+	var errs []error
+	for _, path := range encryption.DiscoverIdentityPaths() {
+		r, err := encryption.IdentityRecipient(path)
+		if err == nil {
+			recipient = r
+			break
+		}
+		errs = append(errs, err)
+	}
+	if recipient == nil && len(errs) == 0 {
+		return fmt.Errorf("no system keyring available and no SSH key or age identity to encrypt the token to")
+	}
+	if recipient == nil {
+		return fmt.Errorf("no system keyring available and no key to encrypt the token to: %w", errors.Join(errs...))
+	}
+
+	path, err := tokenFile(account)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("failed to create token directory: %w", err)
+	}
+
+	var buf bytes.Buffer
+	if err := encryption.Encrypt(&buf, strings.NewReader(token), []age.Recipient{recipient}, true); err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0o600); err != nil {
+		return fmt.Errorf("failed to write token file: %w", err)
+	}
+
+	return nil
+}
+
+// loadTokenFile decrypts the token file of an account with the user's identities
+func loadTokenFile(account string, passphrase encryption.PassphraseFunc) (string, error) {
+	path, err := tokenFile(account)
+	if err != nil {
+		return "", err
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return "", ErrNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read token file: %w", err)
+	}
+
+	identities, err := encryption.LoadIdentityFiles(encryption.DiscoverIdentityPaths(), passphrase, true)
+	if err != nil {
+		return "", err
+	}
+
+	var token bytes.Buffer
+	if err := encryption.Decrypt(&token, bytes.NewReader(data), nil, identities...); err != nil {
+		return "", fmt.Errorf("failed to unlock token file %s: %w", path, err)
+	}
+
+	return token.String(), nil
+}
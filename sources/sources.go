@@ -0,0 +1,43 @@
+package sources
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/deathrjj/age-gitlab-tool-tui/gitea"
+	"github.com/deathrjj/age-gitlab-tool-tui/github"
+	"github.com/deathrjj/age-gitlab-tool-tui/provider"
+)
+
+// FromEnvironment returns the providers configured in the environment besides
+// GitLab: GitHub if GITHUB_ORG or GITHUB_USERS is set, Gitea or Forgejo if
+// GITEA_URL is set, and the local providers of AGE_RECIPIENTS_FILE and
+// AGE_KEYS_DIR, each of which may list several paths separated like PATH
+func FromEnvironment() (provider.Set, error) {
+	var providers provider.Set
+	if github.Configured() {
+		client, err := github.NewClient()
+		if err != nil {
+			return nil, err
+		}
+		providers = append(providers, client)
+	}
+	if gitea.Configured() {
+		client, err := gitea.NewClient()
+		if err != nil {
+			return nil, err
+		}
+		providers = append(providers, client)
+	}
+	for _, path := range filepath.SplitList(os.Getenv("AGE_RECIPIENTS_FILE")) {
+		if path != "" {
+			providers = append(providers, provider.NewFile(path))
+		}
+	}
+	for _, path := range filepath.SplitList(os.Getenv("AGE_KEYS_DIR")) {
+		if path != "" {
+			providers = append(providers, provider.NewDir(path))
+		}
+	}
+	return providers, nil
+}
@@ -0,0 +1,15 @@
+//go:build !unix && !windows
+
+package trust
+
+import "os"
+
+// lockFile does nothing where files cannot be locked
+func lockFile(f *os.File) error {
+	return nil
+}
+
+// unlockFile does nothing where files cannot be locked
+func unlockFile(f *os.File) error {
+	return nil
+}
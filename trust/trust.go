@@ -0,0 +1,241 @@
+package trust
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/deathrjj/age-gitlab-tool-tui/config"
+	"github.com/deathrjj/age-gitlab-tool-tui/encryption"
+	"github.com/deathrjj/age-gitlab-tool-tui/models"
+	"golang.org/x/crypto/ssh"
+)
+
+// Change describes how the keys of a user differ from the ones seen before
+type Change struct {
+	User models.User
+	// Keys are the current keys of the user
+	Keys []models.Key
+	// Added and Removed are the fingerprints of the keys that are new and
+	// the ones that are gone
+	Added   []string
+	Removed []string
+}
+
+// NeedsAcceptance reports whether the user has new keys, which must be
+// accepted before encrypting to them
+func (c Change) NeedsAcceptance() bool {
+	return len(c.Added) > 0
+}
+
+// String describes the change, such as "the keys of gitlab/alice changed:
+// added ssh-ed25519 SHA256:...; removed ssh-rsa SHA256:..."
+func (c Change) String() string {
+	var details []string
+	if len(c.Added) > 0 {
+		details = append(details, "added "+strings.Join(c.Added, ", "))
+	}
+	if len(c.Removed) > 0 {
+		details = append(details, "removed "+strings.Join(c.Removed, ", "))
+	}
+	return fmt.Sprintf("the keys of %s changed: %s", c.User.Key(), strings.Join(details, "; "))
+}
+
+// Store records the fingerprints of the keys seen for every user, per
+// profile, so that keys swapped on the server are noticed (trust on first use)
+type Store struct {
+	path    string
+	profile string
+	// Profiles maps profile names to the fingerprints of every user's keys,
+	// by user key such as "gitlab/alice"
+	Profiles map[string]map[string][]string `json:"profiles"`
+}
+
+// Path returns the location of the known recipients file, next to the
+// configuration file
+func Path() (string, error) {
+	configPath, err := config.Path()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(filepath.Dir(configPath), "known_recipients.json"), nil
+}
+
+// Load reads the keys seen before for the users of a profile
+func Load(profile string) (*Store, error) {
+	path, err := Path()
+	if err != nil {
+		return nil, err
+	}
+	return open(path, profile)
+}
+
+// open reads the known recipients file at path for the users of a profile
+func open(path, profile string) (*Store, error) {
+	s := &Store{path: path, profile: profile}
+	if err := s.read(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// read replaces the keys of the store with the ones in the known recipients
+// file
+func (s *Store) read() error {
+	s.Profiles = nil
+	data, err := os.ReadFile(s.path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read known recipients: %w", err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, s); err != nil {
+			return fmt.Errorf("failed to read %s: %w", s.path, err)
+		}
+	}
+	if s.Profiles == nil {
+		s.Profiles = make(map[string]map[string][]string)
+	}
+	if s.Profiles[s.profile] == nil {
+		s.Profiles[s.profile] = make(map[string][]string)
+	}
+	return nil
+}
+
+// Verify compares the keys of every user with the ones seen before and
+// returns the changes. The keys of users seen for the first time, and of
+// users who only lost keys, are recorded right away. New keys of known users
+// are only recorded once they are accepted with Accept.
+func (s *Store) Verify(userKeys []encryption.UserKeys) ([]Change, error) {
+	var changes []Change
+	err := s.update(func(known map[string][]string) bool {
+		modified := false
+		for _, uk := range userKeys {
+			current := Fingerprints(uk.Keys)
+			previous, seen := known[uk.User.Key()]
+			if !seen {
+				known[uk.User.Key()] = current
+				modified = true
+				continue
+			}
+
+			change := Change{User: uk.User, Keys: uk.Keys, Added: difference(current, previous), Removed: difference(previous, current)}
+			if len(change.Added) == 0 && len(change.Removed) == 0 {
+				continue
+			}
+			changes = append(changes, change)
+			if !change.NeedsAcceptance() {
+				known[uk.User.Key()] = current
+				modified = true
+			}
+		}
+		return modified
+	})
+	if err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
+
+// Accept records the current keys of the users of changes as trusted
+func (s *Store) Accept(changes []Change) error {
+	if len(changes) == 0 {
+		return nil
+	}
+	return s.update(func(known map[string][]string) bool {
+		for _, change := range changes {
+			known[change.User.Key()] = Fingerprints(change.Keys)
+		}
+		return true
+	})
+}
+
+// update rereads the known recipients file, lets fn change the keys of the
+// profile and saves them if fn reports a change. Other processes cannot
+// change the file in between, so that their changes are not lost.
+func (s *Store) update(fn func(known map[string][]string) bool) error {
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o700); err != nil {
+		return fmt.Errorf("failed to create config directory: %w", err)
+	}
+	lock, err := os.OpenFile(s.path+".lock", os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to lock known recipients: %w", err)
+	}
+	defer lock.Close()
+	if err := lockFile(lock); err != nil {
+		return fmt.Errorf("failed to lock known recipients: %w", err)
+	}
+	defer unlockFile(lock)
+
+	if err := s.read(); err != nil {
+		return err
+	}
+	if !fn(s.Profiles[s.profile]) {
+		return nil
+	}
+	return s.save()
+}
+
+// save writes the known recipients file
+func (s *Store) save() error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so a failure keeps the old file intact
+	file, err := os.CreateTemp(filepath.Dir(s.path), ".known_recipients.*")
+	if err != nil {
+		return fmt.Errorf("failed to save known recipients: %w", err)
+	}
+	defer os.Remove(file.Name())
+	_, err = file.Write(append(data, '\n'))
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), s.path)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to save known recipients: %w", err)
+	}
+
+	return nil
+}
+
+// Fingerprints returns the sorted fingerprints of keys: the type and SHA256
+// fingerprint of SSH keys, and native age recipients as they are
+func Fingerprints(keys []models.Key) []string {
+	var fingerprints []string
+	for _, key := range keys {
+		fingerprints = append(fingerprints, Fingerprint(key.Key))
+	}
+	sort.Strings(fingerprints)
+	return fingerprints
+}
+
+// Fingerprint returns the fingerprint of a single key
+func Fingerprint(key string) string {
+	key = strings.TrimSpace(key)
+	if pub, _, _, _, err := ssh.ParseAuthorizedKey([]byte(key)); err == nil {
+		return pub.Type() + " " + ssh.FingerprintSHA256(pub)
+	}
+	return key
+}
+
+// difference returns the elements of a that are not in b
+func difference(a, b []string) []string {
+	inB := make(map[string]bool, len(b))
+	for _, item := range b {
+		inB[item] = true
+	}
+	var diff []string
+	for _, item := range a {
+		if !inB[item] {
+			diff = append(diff, item)
+		}
+	}
+	return diff
+}
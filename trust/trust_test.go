@@ -0,0 +1,184 @@
+package trust
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/deathrjj/age-gitlab-tool-tui/encryption"
+	"github.com/deathrjj/age-gitlab-tool-tui/models"
+	"golang.org/x/crypto/ssh"
+)
+
+var alice = models.User{Username: "alice", Source: "gitlab"}
+
+// newKey returns the public key of a new ed25519 key
+func newKey(t *testing.T) models.Key {
+	t.Helper()
+	public, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicKey, err := ssh.NewPublicKey(public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return models.Key{Key: string(ssh.MarshalAuthorizedKey(publicKey))}
+}
+
+// openStore opens the known recipients file at path for the profile
+func openStore(t *testing.T, path, profile string) *Store {
+	t.Helper()
+	s, err := open(path, profile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// verify compares the keys of alice with the ones seen before
+func verify(t *testing.T, s *Store, keys ...models.Key) []Change {
+	t.Helper()
+	changes, err := s.Verify([]encryption.UserKeys{{User: alice, Keys: keys}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return changes
+}
+
+func TestVerifyFirstSeen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config", "known_recipients.json")
+	key := newKey(t)
+
+	if changes := verify(t, openStore(t, path, "work"), key); len(changes) != 0 {
+		t.Errorf("got changes %v for a user seen for the first time", changes)
+	}
+
+	// The keys are recorded right away
+	s := openStore(t, path, "work")
+	if got, want := s.Profiles["work"][alice.Key()], Fingerprints([]models.Key{key}); !reflect.DeepEqual(got, want) {
+		t.Errorf("got fingerprints %v, want %v", got, want)
+	}
+	if changes := verify(t, s, key); len(changes) != 0 {
+		t.Errorf("got changes %v for unchanged keys", changes)
+	}
+}
+
+func TestVerifyChangedKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "known_recipients.json")
+	oldKey, newerKey := newKey(t), newKey(t)
+	verify(t, openStore(t, path, "work"), oldKey)
+
+	s := openStore(t, path, "work")
+	changes := verify(t, s, newerKey)
+	if len(changes) != 1 || !changes[0].NeedsAcceptance() {
+		t.Fatalf("got changes %v, want one to accept", changes)
+	}
+	if got, want := changes[0].Added, []string{Fingerprint(newerKey.Key)}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got added %v, want %v", got, want)
+	}
+	if got, want := changes[0].Removed, []string{Fingerprint(oldKey.Key)}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got removed %v, want %v", got, want)
+	}
+	if text := changes[0].String(); !strings.HasPrefix(text, "the keys of gitlab/alice changed: added ssh-ed25519 SHA256:") || !strings.Contains(text, "; removed ssh-ed25519 SHA256:") {
+		t.Errorf("got %q", text)
+	}
+
+	// New keys are only recorded once they are accepted
+	if changes := verify(t, openStore(t, path, "work"), newerKey); len(changes) != 1 {
+		t.Fatalf("got changes %v before accepting, want the same change again", changes)
+	}
+	if err := s.Accept(changes); err != nil {
+		t.Fatal(err)
+	}
+	if changes := verify(t, openStore(t, path, "work"), newerKey); len(changes) != 0 {
+		t.Errorf("got changes %v after accepting", changes)
+	}
+}
+
+func TestVerifyRemovedKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "known_recipients.json")
+	kept, removed := newKey(t), newKey(t)
+	verify(t, openStore(t, path, "work"), kept, removed)
+
+	changes := verify(t, openStore(t, path, "work"), kept)
+	if len(changes) != 1 || changes[0].NeedsAcceptance() || len(changes[0].Added) != 0 {
+		t.Fatalf("got changes %v, want one that needs no acceptance", changes)
+	}
+	if got, want := changes[0].Removed, []string{Fingerprint(removed.Key)}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got removed %v, want %v", got, want)
+	}
+
+	// Lost keys are recorded right away
+	if changes := verify(t, openStore(t, path, "work"), kept); len(changes) != 0 {
+		t.Errorf("got changes %v after the removal was recorded", changes)
+	}
+}
+
+func TestVerifySeparatesProfiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "known_recipients.json")
+	verify(t, openStore(t, path, "work"), newKey(t))
+
+	if changes := verify(t, openStore(t, path, "home"), newKey(t)); len(changes) != 0 {
+		t.Errorf("got changes %v for a user first seen in another profile", changes)
+	}
+}
+
+func TestVerifyKeepsConcurrentChanges(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "known_recipients.json")
+
+	// Stores loaded at the same time do not overwrite each other's users
+	const users = 20
+	stores := make([]*Store, users)
+	keys := make([]models.Key, users)
+	for i := range stores {
+		stores[i] = openStore(t, path, "work")
+		keys[i] = newKey(t)
+	}
+	var wg sync.WaitGroup
+	errs := make(chan error, users)
+	for i, s := range stores {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			user := models.User{Username: fmt.Sprintf("user%d", i), Source: "gitlab"}
+			_, err := s.Verify([]encryption.UserKeys{{User: user, Keys: []models.Key{keys[i]}}})
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if known := openStore(t, path, "work").Profiles["work"]; len(known) != users {
+		t.Errorf("got %d users recorded, want %d", len(known), users)
+	}
+	// Only the lock file is left next to the known recipients file
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Errorf("got %d files, want the known recipients and the lock file", len(entries))
+	}
+}
+
+func TestLoadInvalidFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "known_recipients.json")
+	if err := os.WriteFile(path, []byte("{"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := open(path, "work"); err == nil {
+		t.Error("opening an invalid file succeeded")
+	}
+}
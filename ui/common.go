@@ -4,7 +4,9 @@ import (
 	"fmt"
 	"os"
 	"strings"
+	"sync"
 
+	"github.com/deathrjj/age-gitlab-tool-tui/gitlab"
 	"github.com/deathrjj/age-gitlab-tool-tui/models"
 	"github.com/gdamore/tcell/v2"
 	"github.com/rivo/tview"
@@ -14,57 +16,105 @@ import (
 // It prefixes usernames with "- " if unselected or "✓ " if selected.
 func UpdateUserList(list *tview.List, users []models.User, selectedUsers models.UserSelectionMap) {
 	list.Clear()
-	isDemoMode := os.Getenv("AGE_TOOL_DEMO_MODE") != ""
 	
 	for _, user := range users {
 		prefix := "- "
 		color := "white"
-		if selectedUsers[user.ID] {
+		if selectedUsers[user.Key()] {
 			prefix = "✓ "
 			color = "green"
 		}
 		
-		username := user.Username
-		if isDemoMode && len(username) > 2 {
-			// In demo mode, censor all characters after the first two
-			username = username[:2] + strings.Repeat("*", len(username)-2)
+		list.AddItem(fmt.Sprintf("[%s]%s", color, prefix+DisplayUser(user)), "", 0, nil)
+	}
+}
+
+// UpdateGroupList refreshes the list with filtered groups, marking selected ones like UpdateUserList.
+func UpdateGroupList(list *tview.List, groups []models.Group, selectedGroups models.GroupSelectionMap) {
+	list.Clear()
+
+	for _, group := range groups {
+		prefix := "- "
+		color := "white"
+		if selectedGroups[group.ID] {
+			prefix = "✓ "
+			color = "green"
 		}
-		
-		list.AddItem(fmt.Sprintf("[%s]%s", color, prefix+username), "", 0, nil)
+
+		list.AddItem(fmt.Sprintf("[%s]%s", color, prefix+group.FullPath), "", 0, nil)
 	}
 }
 
+// UpdateProjectList refreshes the list with filtered projects, showing the
+// minimum access level of selected ones.
+func UpdateProjectList(list *tview.List, projects []models.Project, selectedProjects models.ProjectSelectionMap) {
+	list.Clear()
+
+	for _, project := range projects {
+		text := "[white]- " + project.PathWithNamespace
+		if minAccess, ok := selectedProjects[project.ID]; ok {
+			text = fmt.Sprintf("[green]✓ %s (%s+)", project.PathWithNamespace, minAccess)
+		}
+
+		list.AddItem(text, "", 0, nil)
+	}
+}
+
+// DisplayUsername returns the username as it should be shown on screen.
+func DisplayUsername(username string) string {
+	if os.Getenv("AGE_TOOL_DEMO_MODE") != "" && len(username) > 2 {
+		// In demo mode, censor all characters after the first two
+		return username[:2] + strings.Repeat("*", len(username)-2)
+	}
+	return username
+}
+
+// DisplayUser returns the username as DisplayUsername does, followed by the
+// source of the user unless it is GitLab.
+func DisplayUser(user models.User) string {
+	if user.Source == "" || user.Source == gitlab.Source {
+		return DisplayUsername(user.Username)
+	}
+	return fmt.Sprintf("%s [gray](%s)[-]", DisplayUsername(user.Username), tview.Escape(user.Source))
+}
+
 // UpdateBottomBar updates the bottom bar text based on current focus.
+// recipientList is the list currently shown in the Recipients panel, keyList
+// the keys of the highlighted user, hasRecipients tells whether any user, group or project has been selected
+// and hasData whether there is text or a file to encrypt.
 func UpdateBottomBar(app *tview.Application, bottomBar *tview.TextView, searchInput *tview.InputField, 
-	userList *tview.List, dataInput *tview.TextArea, encryptButton *tview.Button) {
+	recipientList, keyList *tview.List, hasRecipients, hasData, rekeying bool, dataInput *tview.TextArea, fileView *tview.TextView,
+	encryptButton, fileButton *tview.Button) {
 	
 	focused := app.GetFocus()
 	var text string
 	
-	if focused == userList || focused == searchInput {
-		text = "↑/↓: Move Highlight | ⏎ : Toggle Selection"
-		if searchInput != nil && userList != nil && dataInput != nil {
-			// Check if there's at least one selected user
-			currentSelectedCount := 0
-			for i := 0; i < userList.GetItemCount(); i++ {
-				mainText, _ := userList.GetItemText(i)
-				if strings.Contains(mainText, "✓") {
-					currentSelectedCount++
-				}
-			}
-			
-			if currentSelectedCount > 0 {
-				text += " | ⇥ : Switch to Data"
-			}
+	if focused == recipientList || focused == searchInput {
+		text = "↑/↓: Move Highlight | ⏎ : Toggle Selection | ^G: Users/Groups/Projects | ^P: Profiles"
+		if keyList != nil && keyList.GetItemCount() > 0 {
+			text += " | ^K: Keys"
 		}
+		if hasRecipients {
+			text += " | ⇥ : Switch to Data"
+		}
+	} else if focused == keyList {
+		text = "↑/↓: Move Highlight | ⏎ : Exclude/Include Key | ⇥ : Back to Users"
 	} else if focused == dataInput {
 		text = "⇥ : Switch to Encrypt Button"
+	} else if focused == fileView && rekeying {
+		text = "⇥ : Switch to Encrypt Button"
+	} else if focused == fileView {
+		text = "⌫ : Encrypt Text Instead | ⇥ : Switch to Encrypt Button"
+	} else if focused == encryptButton && rekeying {
+		text = "⏎ : Re-encrypt | ⇥ : Switch to Recipients"
 	} else if focused == encryptButton {
-		if dataInput != nil && dataInput.GetText() != "" {
-			text = "⏎ : Encrypt | ⇥ : Switch to Recipients"
+		if hasData {
+			text = "⏎ : Encrypt | ⇥ : Switch to File Button"
 		} else {
-			text = "⇥ : Switch to Recipients"
+			text = "⇥ : Switch to File Button"
 		}
+	} else if focused == fileButton {
+		text = "⏎ : Choose File or Directory to Encrypt | ⇥ : Switch to Recipients"
 	}
 	
 	bottomBar.SetText(text)
@@ -75,6 +125,67 @@ func ContainsCaseInsensitive(s, substr string) bool {
 	return strings.Contains(strings.ToLower(s), strings.ToLower(substr))
 }
 
+// ErrorMessage returns the message of an error, followed by what to do about
+// it if it is a GitLab API error, such as a token that lacks a scope
+func ErrorMessage(err error) string {
+	if advice := gitlab.Advice(err); advice != "" {
+		return err.Error() + "\n\n" + advice
+	}
+	return err.Error()
+}
+
+// output is the result printed once the UI has stopped, such as the
+// encrypted message, as the terminal belongs to the UI until then
+var output struct {
+	sync.Mutex
+	text string
+}
+
+// stopWithOutput stops the UI to print text with PrintOutput. It may be
+// called from any goroutine.
+func stopWithOutput(app *tview.Application, text string) {
+	output.Lock()
+	output.text = text
+	output.Unlock()
+	go app.QueueUpdate(app.Stop)
+}
+
+// PrintOutput prints the result remembered by stopWithOutput to stdout. It is
+// called once the UI has stopped.
+func PrintOutput() {
+	output.Lock()
+	defer output.Unlock()
+	if output.text != "" {
+		fmt.Println(output.text)
+	}
+	output.text = ""
+}
+
+// warnings are printed once the UI has stopped, as the terminal belongs to
+// the UI until then
+var warnings struct {
+	sync.Mutex
+	errs []error
+}
+
+// Warn remembers an error that does not stop the UI, such as a refreshed
+// login that could not be saved, to print it with PrintWarnings
+func Warn(err error) {
+	warnings.Lock()
+	defer warnings.Unlock()
+	warnings.errs = append(warnings.errs, err)
+}
+
+// PrintWarnings prints the warnings remembered by Warn to stderr
+func PrintWarnings() {
+	warnings.Lock()
+	defer warnings.Unlock()
+	for _, err := range warnings.errs {
+		fmt.Fprintf(os.Stderr, "age-gitlab-tool-tui: warning: %v\n", err)
+	}
+	warnings.errs = nil
+}
+
 // CreateErrorModal creates a modal to display error messages
 func CreateErrorModal(app *tview.Application, message string, returnFocus tview.Primitive) *tview.Modal {
 	return tview.NewModal().
@@ -85,6 +196,37 @@ func CreateErrorModal(app *tview.Application, message string, returnFocus tview.
 		})
 }
 
+// CreateConfirmView creates a view showing a scrollable text with buttons to
+// confirm or cancel an action
+func CreateConfirmView(title, text, confirmLabel string, onConfirm, onCancel func()) tview.Primitive {
+	textView := tview.NewTextView().
+		SetDynamicColors(true).
+		SetScrollable(true).
+		SetText(text)
+
+	buttons := tview.NewForm().
+		AddButton(confirmLabel, onConfirm).
+		AddButton("Cancel", onCancel).
+		SetButtonsAlign(tview.AlignCenter)
+	buttons.SetCancelFunc(onCancel)
+	buttons.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		// Scroll the text while keeping the focus on the buttons
+		switch event.Key() {
+		case tcell.KeyUp, tcell.KeyDown, tcell.KeyPgUp, tcell.KeyPgDn:
+			textView.InputHandler()(event, func(p tview.Primitive) {})
+			return nil
+		}
+		return event
+	})
+
+	view := tview.NewFlex().SetDirection(tview.FlexRow).
+		AddItem(textView, 0, 1, false).
+		AddItem(buttons, 3, 0, true)
+	view.SetBorder(true).SetTitle(title)
+
+	return view
+}
+
 // SetupKeyboardNavigation sets up common keyboard shortcuts for navigation between components
 func SetupKeyboardNavigation(app *tview.Application, components ...tview.Primitive) {
 	for i, component := range components {
@@ -1,18 +1,35 @@
 package ui
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"os"
+	"path/filepath"
 	"strings"
 
+	"filippo.io/age"
+	"github.com/deathrjj/age-gitlab-tool-tui/archive"
+	"github.com/deathrjj/age-gitlab-tool-tui/config"
 	"github.com/deathrjj/age-gitlab-tool-tui/encryption"
+	"github.com/deathrjj/age-gitlab-tool-tui/gitlab"
+	"github.com/deathrjj/age-gitlab-tool-tui/provider"
+	"github.com/deathrjj/age-gitlab-tool-tui/sources"
 	"github.com/rivo/tview"
 )
 
+// errPassphraseCancelled is returned by the passphrase prompt when the user cancels it
+var errPassphraseCancelled = errors.New("passphrase entry cancelled")
+
 // DecryptionUI handles the decryption UI flow
 type DecryptionUI struct {
 	App           *tview.Application
 	EncryptedText string
+
+	// rekey re-encrypts the decrypted content instead of printing it
+	rekey bool
 }
 
 // NewDecryptionUI creates a new decryption UI instance
@@ -27,27 +44,133 @@ func NewDecryptionUI(app *tview.Application, encryptedText string) *DecryptionUI
 func (ui *DecryptionUI) PromptForDecryption() {
 	modal := tview.NewModal().
 		SetText("Age file detected in clipboard. Would you like to decrypt it?").
-		AddButtons([]string{"Yes", "No"}).
+		AddButtons([]string{"Yes", "Inspect", "Rekey", "No"}).
 		SetDoneFunc(func(buttonIndex int, buttonLabel string) {
-			if buttonLabel == "Yes" {
-				// Check if private key path is set
-				privateKeyPath := os.Getenv("AGE_PRIVATE_KEY_PATH")
-				if privateKeyPath == "" {
-					// Prompt for private key path
-					ui.PromptForPrivateKeyPath()
-					return
-				}
+			switch buttonLabel {
+			case "Yes":
+				ui.rekey = false
+				ui.StartDecryption()
+			case "Rekey":
+				ui.rekey = true
+				ui.StartDecryption()
+			case "Inspect":
+				ui.InspectRecipients()
+			default:
+				// Continue with normal app flow
+				encryptionUI := NewEncryptionUI(ui.App)
+				encryptionUI.StartEncryptionUI()
+			}
+		})
 
-				// Try to decrypt without passphrase first
-				decrypted, err := encryption.DecryptAgeFile(ui.EncryptedText, privateKeyPath, "")
-				if err != nil {
-					if strings.Contains(err.Error(), "please provide passphrase") {
-						// Key is passphrase protected, prompt for passphrase
-						ui.PromptForPassphrase(privateKeyPath)
-						return
-					}
-					
-					// Other error
+	ui.App.SetRoot(modal, true)
+}
+
+// StartDecryption tries every key that can be found, and asks for the path
+// to a key if there are none
+func (ui *DecryptionUI) StartDecryption() {
+	keyPaths := encryption.DiscoverIdentityPaths()
+	if len(keyPaths) == 0 {
+		ui.PromptForPrivateKeyPath("")
+		return
+	}
+	ui.DecryptWithKeys(keyPaths, true)
+}
+
+// InspectRecipients shows who the age file was encrypted to, matching the
+// SSH recipients against the keys of the GitLab users
+func (ui *DecryptionUI) InspectRecipients() {
+	loadingText := tview.NewTextView().
+		SetText("Looking up recipients...").
+		SetTextAlign(tview.AlignCenter)
+	ui.App.SetRoot(loadingText, true)
+
+	go func() {
+		recipients, err := encryption.ReadRecipients(strings.NewReader(ui.EncryptedText))
+		if err != nil {
+			ui.App.QueueUpdateDraw(func() {
+				errorModal := tview.NewModal().
+					SetText(fmt.Sprintf("Error inspecting: %v", err)).
+					AddButtons([]string{"OK"}).
+					SetDoneFunc(func(buttonIndex int, buttonLabel string) {
+						ui.PromptForDecryption()
+					})
+				ui.App.SetRoot(errorModal, true)
+			})
+			return
+		}
+
+		// Without GitLab access the recipients are still listed, just not named
+		// unless they belong to another provider
+		var note string
+		providers, err := sources.FromEnvironment()
+		if err != nil {
+			note = fmt.Sprintf("[red]%s[white]", tview.Escape(err.Error()))
+		}
+		if gitlabClient, err := gitlab.NewClient(); err != nil {
+			note = "Set GITLAB_URL and GITLAB_TOKEN to match recipients to GitLab users."
+		} else {
+			providers = append(provider.Set{gitlabClient}, providers...)
+		}
+		if len(providers) > 0 {
+			if users, err := providers.ListUsers(context.Background()); err != nil {
+				note = fmt.Sprintf("[red]Could not fetch users: %s[white]", tview.Escape(ErrorMessage(err)))
+			} else if err := encryption.IdentifyRecipients(context.Background(), recipients, users, providers); err != nil {
+				note = fmt.Sprintf("[red]Could not match recipients to users: %s[white]", tview.Escape(ErrorMessage(err)))
+			}
+		}
+
+		for i := range recipients {
+			recipients[i].Username = DisplayUsername(recipients[i].Username)
+		}
+
+		var text strings.Builder
+		for _, recipient := range recipients {
+			fmt.Fprintf(&text, "%s\n", tview.Escape(encryption.DescribeRecipient(recipient)))
+		}
+		if note != "" {
+			fmt.Fprintf(&text, "\n%s\n", note)
+		}
+
+		ui.App.QueueUpdateDraw(func() {
+			view := CreateConfirmView("Recipients", text.String(), "Decrypt",
+				func() {
+					ui.rekey = false
+					ui.StartDecryption()
+				}, ui.PromptForDecryption)
+			ui.App.SetRoot(view, true)
+		})
+	}()
+}
+
+// DecryptWithKeys decrypts the age file with the identities in the given key
+// files, prompting for passphrases as needed. If the keys were discovered
+// rather than entered and none of them match, the user is asked for a key path.
+func (ui *DecryptionUI) DecryptWithKeys(keyPaths []string, discovered bool) {
+	loadingText := tview.NewTextView().
+		SetText("Decrypting...").
+		SetTextAlign(tview.AlignCenter)
+	ui.App.SetRoot(loadingText, true)
+
+	go func() {
+		// When re-encrypting, the file is only checked to decrypt here, as it
+		// is decrypted again while encrypting
+		var decrypted bytes.Buffer
+		var dst io.Writer = &decrypted
+		if ui.rekey {
+			dst = io.Discard
+		}
+		identities, err := ui.decrypt(dst, keyPaths, discovered)
+		if err != nil {
+			var noMatch *age.NoIdentityMatchError
+			ui.App.QueueUpdateDraw(func() {
+				switch {
+				case errors.Is(err, errPassphraseCancelled):
+					encryptionUI := NewEncryptionUI(ui.App)
+					encryptionUI.StartEncryptionUI()
+				case discovered && errors.As(err, &noMatch):
+					ui.PromptForPrivateKeyPath(fmt.Sprintf("None of these keys can decrypt the file:\n%s",
+						strings.Join(keyPaths, "\n")))
+				default:
 					errorModal := tview.NewModal().
 						SetText(fmt.Sprintf("Error decrypting: %v", err)).
 						AddButtons([]string{"OK"}).
@@ -55,118 +178,204 @@ func (ui *DecryptionUI) PromptForDecryption() {
 							ui.App.Stop()
 						})
 					ui.App.SetRoot(errorModal, true)
-					return
 				}
+			})
+			return
+		}
 
-				// Show decrypted message and exit
-				ui.App.Stop()
-				fmt.Println("Decrypted message:")
-				fmt.Println(decrypted)
-			} else {
-				// Continue with normal app flow
-				encryptionUI := NewEncryptionUI(ui.App)
-				encryptionUI.StartEncryptionUI()
-			}
-		})
+		if ui.rekey {
+			ui.App.QueueUpdateDraw(func() {
+				ui.startRekey(identities)
+			})
+			return
+		}
 
-	ui.App.SetRoot(modal, true)
+		// Encrypted directories are unpacked rather than printed
+		if archive.IsArchive(decrypted.Bytes()) {
+			ui.App.QueueUpdateDraw(func() {
+				ui.PromptForExtraction(decrypted.String())
+			})
+			return
+		}
+
+		// Show decrypted message and exit
+		stopWithOutput(ui.App, "Decrypted message:\n"+decrypted.String())
+	}()
+}
+
+// startRekey opens the encryption UI with the current recipients of the file
+// preselected, to re-encrypt it with the identities that decrypt it
+func (ui *DecryptionUI) startRekey(identities []age.Identity) {
+	recipients, err := encryption.ReadRecipients(strings.NewReader(ui.EncryptedText))
+	if err != nil {
+		recipients = []encryption.FileRecipient{}
+	}
+
+	encryptionUI := NewEncryptionUI(ui.App)
+	encryptionUI.RekeyCiphertext = ui.EncryptedText
+	encryptionUI.RekeyIdentities = identities
+	encryptionUI.RekeyRecipients = recipients
+	encryptionUI.StartEncryptionUI()
+}
+
+// PromptForExtraction asks where to unpack a decrypted directory archive
+func (ui *DecryptionUI) PromptForExtraction(decrypted string) {
+	form := tview.NewForm()
+
+	dir := "."
+
+	form.AddTextView("", "The message is an encrypted directory.", 0, 1, false, false)
+	form.AddInputField("Extract into:", dir, 50, nil, func(text string) {
+		dir = text
+	})
+
+	form.AddButton("Extract", func() {
+		if err := archive.Extract(strings.NewReader(decrypted), dir); err != nil {
+			errorModal := CreateErrorModal(ui.App, fmt.Sprintf("Error extracting: %v", err), form)
+			ui.App.SetRoot(errorModal, true)
+			return
+		}
+		stopWithOutput(ui.App, "Extracted to "+dir)
+	})
+
+	form.AddButton("Cancel", func() {
+		ui.App.Stop()
+	})
+
+	form.SetBorder(true).SetTitle("Extract Directory").SetTitleAlign(tview.AlignCenter)
+	ui.App.SetRoot(form, true)
+	ui.App.SetFocus(form)
 }
 
-// PromptForPrivateKeyPath shows a form to enter the AGE_PRIVATE_KEY_PATH
-func (ui *DecryptionUI) PromptForPrivateKeyPath() {
+// decrypt loads the identities and decrypts the age file to dst. It returns
+// the identities of the key files, which decrypt the file again without
+// asking for passphrases. It must not be called from the UI goroutine, as
+// passphrase prompts block until answered.
+func (ui *DecryptionUI) decrypt(dst io.Writer, keyPaths []string, discovered bool) ([]age.Identity, error) {
+	identities, err := encryption.LoadIdentityFiles(keyPaths, ui.askPassphrase, discovered)
+	if err != nil {
+		return nil, err
+	}
+
+	// Fall back to ssh-agent to explain which key is needed
+	all := identities
+	if agentIdentity, err := encryption.DialAgent(); err == nil {
+		defer agentIdentity.Close()
+		all = append(all, agentIdentity)
+	}
+
+	if err := encryption.Decrypt(dst, strings.NewReader(ui.EncryptedText), nil, all...); err != nil {
+		return nil, err
+	}
+	return identities, nil
+}
+
+// askPassphrase is an encryption.PassphraseFunc that shows the passphrase
+// prompt and waits for the user to answer it
+func (ui *DecryptionUI) askPassphrase(path string, retry bool) (string, error) {
+	entered := make(chan string, 1)
+	cancelled := make(chan struct{}, 1)
+
+	ui.App.QueueUpdateDraw(func() {
+		ui.PromptForPassphrase(path, retry,
+			func(passphrase string) { entered <- passphrase },
+			func() { cancelled <- struct{}{} })
+	})
+
+	select {
+	case passphrase := <-entered:
+		ui.App.QueueUpdateDraw(func() {
+			loadingText := tview.NewTextView().
+				SetText("Decrypting...").
+				SetTextAlign(tview.AlignCenter)
+			ui.App.SetRoot(loadingText, true)
+		})
+		return passphrase, nil
+	case <-cancelled:
+		return "", errPassphraseCancelled
+	}
+}
+
+// PromptForPrivateKeyPath shows a form to enter the AGE_PRIVATE_KEY_PATH,
+// with an optional message explaining why it is needed
+func (ui *DecryptionUI) PromptForPrivateKeyPath(message string) {
 	form := tview.NewForm()
-	
+
 	var keyPath string
-	
-	form.AddInputField("Path to SSH private key:", "", 50, nil, func(text string) {
+
+	if message != "" {
+		form.AddTextView("", message, 0, strings.Count(message, "\n")+1, false, false)
+	}
+
+	form.AddInputField("Path to SSH private key or age identity file:", "", 50, nil, func(text string) {
 		keyPath = text
 	})
-	
+
 	form.AddButton("Continue", func() {
 		if keyPath == "" {
 			errorModal := CreateErrorModal(ui.App, "Please enter a valid file path", form)
 			ui.App.SetRoot(errorModal, true)
 			return
 		}
-		
+
 		// Check if file exists
 		if _, err := os.Stat(keyPath); os.IsNotExist(err) {
 			errorModal := CreateErrorModal(ui.App, "File does not exist. Please enter a valid path.", form)
 			ui.App.SetRoot(errorModal, true)
 			return
 		}
-		
+
 		// Set environment variable
 		os.Setenv("AGE_PRIVATE_KEY_PATH", keyPath)
-		
-		// Continue with decryption
-		privateKeyPath := keyPath
-		decrypted, err := encryption.DecryptAgeFile(ui.EncryptedText, privateKeyPath, "")
-		if err != nil {
-			if strings.Contains(err.Error(), "please provide passphrase") {
-				// Key is passphrase protected, prompt for passphrase
-				ui.PromptForPassphrase(privateKeyPath)
-				return
-			}
-			
-			// Other error
-			errorModal := tview.NewModal().
-				SetText(fmt.Sprintf("Error decrypting: %v", err)).
-				AddButtons([]string{"OK"}).
-				SetDoneFunc(func(buttonIndex int, buttonLabel string) {
-					ui.App.Stop()
-				})
-			ui.App.SetRoot(errorModal, true)
-			return
-		}
 
-		// Show decrypted message and exit
-		ui.App.Stop()
-		fmt.Println("Decrypted message:")
-		fmt.Println(decrypted)
+		// Continue with decryption, once the path is saved or not
+		OfferToSaveProfile(ui.App, "key path", func(name string) error {
+			return config.Update(name, func(profile *config.Profile) {
+				if absPath, err := filepath.Abs(keyPath); err == nil {
+					profile.PrivateKeyPath = absPath
+				} else {
+					profile.PrivateKeyPath = keyPath
+				}
+			})
+		}, func() {
+			ui.DecryptWithKeys([]string{keyPath}, false)
+		})
 	})
-	
+
 	form.AddButton("Cancel", func() {
 		encryptionUI := NewEncryptionUI(ui.App)
 		encryptionUI.StartEncryptionUI()
 	})
-	
+
 	form.SetBorder(true).SetTitle("SSH Private Key Path").SetTitleAlign(tview.AlignCenter)
 	ui.App.SetRoot(form, true)
 	ui.App.SetFocus(form)
 }
 
-// PromptForPassphrase shows a prompt for entering the SSH key passphrase
-func (ui *DecryptionUI) PromptForPassphrase(privateKeyPath string) {
+// PromptForPassphrase shows a prompt for entering the passphrase of the SSH
+// key at privateKeyPath. retry indicates the previous passphrase was incorrect.
+func (ui *DecryptionUI) PromptForPassphrase(privateKeyPath string, retry bool, done func(passphrase string), cancel func()) {
 	form := tview.NewForm()
-	
+
 	var passphrase string
-	
+
+	message := "Enter the passphrase for " + privateKeyPath
+	if retry {
+		message = "[red]Incorrect passphrase.[white] Try again for " + privateKeyPath
+	}
+	form.AddTextView("", message, 0, 1, true, false)
+
 	form.AddPasswordField("Passphrase:", "", 50, '*', func(text string) {
 		passphrase = text
 	})
-	
+
 	form.AddButton("Decrypt", func() {
-		// Try to decrypt with provided passphrase
-		decrypted, err := encryption.DecryptAgeFile(ui.EncryptedText, privateKeyPath, passphrase)
-		if err != nil {
-			errorModal := CreateErrorModal(ui.App, fmt.Sprintf("Error decrypting: %v", err), form)
-			ui.App.SetRoot(errorModal, true)
-			return
-		}
-		
-		// Show decrypted message and exit
-		ui.App.Stop()
-		fmt.Println("Decrypted message:")
-		fmt.Println(decrypted)
+		done(passphrase)
 	})
-	
-	form.AddButton("Cancel", func() {
-		encryptionUI := NewEncryptionUI(ui.App)
-		encryptionUI.StartEncryptionUI()
-	})
-	
+
+	form.AddButton("Cancel", cancel)
+
 	form.SetBorder(true).SetTitle("SSH Key Passphrase").SetTitleAlign(tview.AlignCenter)
 	ui.App.SetRoot(form, true)
 	ui.App.SetFocus(form)
-} 
+}
@@ -1,31 +1,70 @@
 package ui
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"os"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 
+	"filippo.io/age"
+	"github.com/deathrjj/age-gitlab-tool-tui/cache"
+	"github.com/deathrjj/age-gitlab-tool-tui/config"
 	"github.com/deathrjj/age-gitlab-tool-tui/encryption"
 	"github.com/deathrjj/age-gitlab-tool-tui/gitlab"
 	"github.com/deathrjj/age-gitlab-tool-tui/models"
+	"github.com/deathrjj/age-gitlab-tool-tui/provider"
+	"github.com/deathrjj/age-gitlab-tool-tui/secrets"
+	"github.com/deathrjj/age-gitlab-tool-tui/sources"
 	"github.com/gdamore/tcell/v2"
 	"github.com/rivo/tview"
 )
 
 // EncryptionUI handles the encryption UI flow
 type EncryptionUI struct {
-	App           *tview.Application
-	AllUsers      []models.User
-	FilteredUsers []models.User
-	SelectedUsers models.UserSelectionMap
-	GitlabClient  *gitlab.Client
+	App              *tview.Application
+	AllUsers         []models.User
+	FilteredUsers    []models.User
+	SelectedUsers    models.UserSelectionMap
+	AllGroups        []models.Group
+	FilteredGroups   []models.Group
+	SelectedGroups   models.GroupSelectionMap
+	AllProjects      []models.Project
+	FilteredProjects []models.Project
+	SelectedProjects models.ProjectSelectionMap
+	ExcludedKeys     models.KeyExclusionMap
+	GitlabClient     *gitlab.Client
+	Providers        provider.Set
+
+	// InitialData prefills the Data panel, and RekeyRecipients are the
+	// recipients of a file being re-encrypted, preselected once matched to
+	// users
+	InitialData     string
+	RekeyRecipients []encryption.FileRecipient
+
+	// RekeyCiphertext is the file being re-encrypted instead of the Data
+	// panel. It is decrypted with RekeyIdentities while encrypting, so its
+	// content is never shown.
+	RekeyCiphertext string
+	RekeyIdentities []age.Identity
+
+	// SelectedFile is the file or directory to encrypt instead of the text
+	// in the Data panel
+	SelectedFile string
 }
 
 // NewEncryptionUI creates a new encryption UI instance
 func NewEncryptionUI(app *tview.Application) *EncryptionUI {
 	return &EncryptionUI{
-		App:           app,
-		SelectedUsers: make(models.UserSelectionMap),
+		App:              app,
+		SelectedUsers:    make(models.UserSelectionMap),
+		SelectedGroups:   make(models.GroupSelectionMap),
+		SelectedProjects: make(models.ProjectSelectionMap),
+		ExcludedKeys:     make(models.KeyExclusionMap),
 	}
 }
 
@@ -44,11 +83,11 @@ func (ui *EncryptionUI) StartEncryptionUI() {
 		return
 	}
 	
-	// Then check if GitLab token is set
+	// Then check if GitLab token is set, which is not needed offline
 	token := os.Getenv("GITLAB_TOKEN")
-	if token == "" {
-		// Then prompt for GitLab token
-		ui.PromptForGitLabToken()
+	if token == "" && !cache.Offline() {
+		// Then use the stored token, or prompt for GitLab token
+		ui.LoadStoredToken()
 		return
 	}
 	
@@ -76,12 +115,12 @@ func (ui *EncryptionUI) PromptForGitLabURL() {
 		// Set environment variable
 		os.Setenv("GITLAB_URL", gitlabURL)
 		
-		// Now check for token
+		// Now check for token, which is not needed offline
 		token := os.Getenv("GITLAB_TOKEN")
-		if token == "" {
-			ui.PromptForGitLabToken()
+		if token == "" && !cache.Offline() {
+			ui.LoadStoredToken()
 		} else {
-			ui.LoadUsers()
+			ui.offerToSaveGitLab()
 		}
 	})
 	
@@ -115,9 +154,14 @@ func (ui *EncryptionUI) PromptForGitLabToken() {
 		os.Setenv("GITLAB_TOKEN", gitlabToken)
 		
 		// Continue with loading users
-		ui.LoadUsers()
+		ui.offerToSaveGitLab()
 	})
 	
+	// With an OAuth application configured, logging in is an alternative
+	if os.Getenv("GITLAB_OAUTH_CLIENT_ID") != "" {
+		form.AddButton("Log In", ui.Login)
+	}
+
 	form.AddButton("Cancel", func() {
 		ui.App.Stop()
 	})
@@ -127,17 +171,160 @@ func (ui *EncryptionUI) PromptForGitLabToken() {
 	ui.App.SetFocus(form)
 }
 
+// offerToSaveGitLab offers to save the GitLab URL entered at the prompts to
+// the active profile, and the token to the keyring or token file, then loads
+// the users
+func (ui *EncryptionUI) offerToSaveGitLab() {
+	OfferToSaveProfile(ui.App, "GitLab URL and token", func(name string) error {
+		err := config.Update(name, func(profile *config.Profile) {
+			profile.GitLabURL = os.Getenv("GITLAB_URL")
+		})
+		if err != nil {
+			return err
+		}
+		_, err = secrets.SaveToken(name, os.Getenv("GITLAB_TOKEN"))
+		return err
+	}, ui.LoadUsers)
+}
+
+// Login logs in to GitLab with OAuth in the browser, using the application in
+// GITLAB_OAUTH_CLIENT_ID, and offers to store the token for the active profile
+func (ui *EncryptionUI) Login() {
+	ctx, cancel := context.WithCancel(context.Background())
+	baseURL := os.Getenv("GITLAB_URL")
+	redirectURI := os.Getenv("GITLAB_OAUTH_REDIRECT_URI")
+	if redirectURI == "" {
+		redirectURI = gitlab.DefaultRedirectURI
+	}
+	clientID := os.Getenv("GITLAB_OAUTH_CLIENT_ID")
+	cfg := gitlab.OAuthConfig(baseURL, clientID, redirectURI, nil)
+
+	waiting := tview.NewModal().
+		SetText("Waiting for the login in the browser...").
+		AddButtons([]string{"Cancel"}).
+		SetDoneFunc(func(buttonIndex int, buttonLabel string) { cancel() })
+	ui.App.SetRoot(waiting, false)
+
+	go func() {
+		defer cancel()
+		token, err := gitlab.LoginWithBrowser(ctx, cfg, func(authURL string) {
+			ui.App.QueueUpdateDraw(func() {
+				waiting.SetText("Log in to GitLab in your browser. If it does not open by itself, open:\n\n" + authURL)
+			})
+			gitlab.OpenBrowser(authURL)
+		})
+		// Refreshed tokens are only stored once the login has been saved,
+		// until then the latest one is kept to be saved. Tokens are refreshed
+		// while fetching, not on the UI goroutine.
+		var client *gitlab.Client
+		var savedMu sync.Mutex
+		var saved string
+		credential := &gitlab.OAuthCredential{ClientID: clientID, Token: token}
+		if err == nil {
+			client, err = gitlab.NewOAuthClient(baseURL, credential, func(refreshed *gitlab.OAuthCredential) error {
+				savedMu.Lock()
+				defer savedMu.Unlock()
+				credential = refreshed
+				if saved == "" {
+					return nil
+				}
+				_, err := secrets.SaveOAuthToken(saved, refreshed)
+				return err
+			}, Warn)
+		}
+
+		ui.App.QueueUpdateDraw(func() {
+			switch {
+			case errors.Is(err, context.Canceled):
+				ui.PromptForGitLabToken()
+			case err != nil:
+				modal := tview.NewModal().
+					SetText(fmt.Sprintf("Login failed: %v", err)).
+					AddButtons([]string{"OK"}).
+					SetDoneFunc(func(buttonIndex int, buttonLabel string) { ui.PromptForGitLabToken() })
+				ui.App.SetRoot(modal, false)
+			default:
+				ui.GitlabClient = client
+				OfferToSaveProfile(ui.App, "GitLab URL and login", func(name string) error {
+					err := config.Update(name, func(profile *config.Profile) {
+						profile.GitLabURL = baseURL
+						profile.OAuthClientID = clientID
+					})
+					if err != nil {
+						return err
+					}
+					savedMu.Lock()
+					defer savedMu.Unlock()
+					if _, err := secrets.SaveOAuthToken(name, credential); err != nil {
+						return err
+					}
+					saved = name
+					return nil
+				}, ui.LoadUsers)
+			}
+		})
+	}()
+}
+
+// LoadStoredToken unlocks the GitLab token or OAuth login stored for the
+// active profile, asking for the passphrase of the key its file is encrypted
+// to if needed, and prompts for a token if none is stored
+func (ui *EncryptionUI) LoadStoredToken() {
+	loadingText := tview.NewTextView().
+		SetText("Unlocking the stored GitLab token...").
+		SetTextAlign(tview.AlignCenter)
+	ui.App.SetRoot(loadingText, true)
+
+	go func() {
+		client, err := secrets.NewGitLabClient(config.Active(), NewDecryptionUI(ui.App, "").askPassphrase, Warn)
+		ui.App.QueueUpdateDraw(func() {
+			switch {
+			case err == nil:
+				ui.GitlabClient = client
+				ui.LoadUsers()
+			case errors.Is(err, secrets.ErrNotFound), errors.Is(err, errPassphraseCancelled):
+				ui.PromptForGitLabToken()
+			default:
+				modal := tview.NewModal().
+					SetText(fmt.Sprintf("Could not unlock the stored GitLab token: %v", err)).
+					AddButtons([]string{"Enter Token", "Quit"}).
+					SetDoneFunc(func(buttonIndex int, buttonLabel string) {
+						if buttonLabel == "Enter Token" {
+							ui.PromptForGitLabToken()
+						} else {
+							ui.App.Stop()
+						}
+					})
+				ui.App.SetRoot(modal, false)
+			}
+		})
+	}()
+}
+
 // LoadUsers fetches users from GitLab and initializes the encryption UI
 func (ui *EncryptionUI) LoadUsers() {
+	// ctx is cancelled with Esc while the users are loaded
+	ctx, cancel := context.WithCancel(context.Background())
+
 	loadingText := tview.NewTextView().
-		SetText("Loading users...").
+		SetText("Loading users...\n\nEsc: Cancel").
 		SetTextAlign(tview.AlignCenter)
+	loadingText.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		if event.Key() == tcell.KeyEscape {
+			cancel()
+			return nil
+		}
+		return event
+	})
 	ui.App.SetRoot(loadingText, true)
 
-	// Initialize GitLab client
+	// Initialize GitLab client, unless a stored login already provided one
 	var err error
-	ui.GitlabClient, err = gitlab.NewClient()
+	if ui.GitlabClient == nil {
+		ui.GitlabClient, err = gitlab.NewClient()
+	}
 	if err != nil {
+		cancel()
 		ui.App.QueueUpdateDraw(func() {
 			modal := tview.NewModal().
 				SetText(fmt.Sprintf("Error initializing GitLab client: %v", err)).
@@ -147,22 +334,687 @@ func (ui *EncryptionUI) LoadUsers() {
 		})
 		return
 	}
+	providers, err := sources.FromEnvironment()
+	if err != nil {
+		cancel()
+		ui.App.QueueUpdateDraw(func() {
+			modal := tview.NewModal().
+				SetText(fmt.Sprintf("Error initializing recipient sources: %v", err)).
+				AddButtons([]string{"Quit"}).
+				SetDoneFunc(func(buttonIndex int, buttonLabel string) { ui.App.Stop() })
+			ui.App.SetRoot(modal, false)
+		})
+		return
+	}
+	ui.Providers = append(provider.Set{ui.GitlabClient}, providers...)
 
 	// Declare UI components
 	var searchInput *tview.InputField
+	var userList, groupList, projectList *tview.List
+	var keyList *tview.List
+	var recipientPages *tview.Pages
+	var usersPanel *tview.Flex
 	var dataInput *tview.TextArea
+	var dataPages *tview.Pages
+	var fileView *tview.TextView
+	var fileButton *tview.Button
 	var layout tview.Primitive
 	var bottomBar *tview.TextView
 	var encryptButton *tview.Button
+	var activeTab string
+
+	// The groups, projects and keys being fetched, and the user list being
+	// refreshed, are cancelled with these
+	var cancelGroups, cancelProjects context.CancelFunc
+	cancelRefresh := func() {}
+
+	// keysUser is the user whose keys are shown, and userKeys the keys
+	// fetched so far, by user key
+	var keysUser models.User
+	userKeys := make(map[string][]models.Key)
+	fetchingKeys := make(map[string]context.CancelFunc)
+	var showKeys func(index int)
+
+	// activeList returns the list currently shown in the Recipients panel
+	activeList := func() *tview.List {
+		switch activeTab {
+		case "Groups":
+			return groupList
+		case "Projects":
+			return projectList
+		}
+		return userList
+	}
+
+	// dataView returns the text area, or the file details once a file is chosen
+	dataView := func() tview.Primitive {
+		if ui.SelectedFile != "" || ui.RekeyCiphertext != "" {
+			return fileView
+		}
+		return dataInput
+	}
+
+	updateBottomBar := func() {
+		hasRecipients := ui.HasRecipients()
+		hasData := (dataInput != nil && dataInput.GetText() != "") || ui.SelectedFile != "" || ui.RekeyCiphertext != ""
+		UpdateBottomBar(ui.App, bottomBar, searchInput, activeList(), keyList, hasRecipients, hasData,
+			ui.RekeyCiphertext != "", dataInput, fileView, encryptButton, fileButton)
+	}
+
+	// panelTitle returns the title of the Recipients panel, naming the
+	// active profile if there is one
+	panelTitle := func() string {
+		title := "Recipients - " + activeTab
+		if profile := os.Getenv(config.ProfileEnv); profile != "" {
+			title += " (" + profile + ")"
+		}
+		if gitlab.InsecureSkipVerify() {
+			title += " - TLS NOT VERIFIED"
+		}
+		return title
+	}
+
+	// switchProfile shows the profiles to choose from, and starts over with
+	// the chosen one, keeping the data to encrypt
+	switchProfile := func() {
+		switcher := CreateProfileSwitcher(ui.App, func(name string) {
+			cancelRefresh()
+			ui.restart(dataInput.GetText())
+		}, func() {
+			ui.App.SetRoot(layout, true).SetFocus(activeList())
+			updateBottomBar()
+		})
+		ui.App.SetRoot(switcher, true)
+	}
+
+	// showError shows a message and returns to the main layout once dismissed
+	showError := func(message string) {
+		modal := tview.NewModal().
+			SetText(message).
+			AddButtons([]string{"OK"}).
+			SetDoneFunc(func(buttonIndex int, buttonLabel string) {
+				ui.App.SetRoot(layout, true).SetFocus(dataView())
+				updateBottomBar()
+			})
+		ui.App.SetRoot(modal, false)
+	}
+
+	// applyFilter filters users and groups by the search text
+	applyFilter := func(text string) {
+		ui.FilteredUsers = nil
+		for _, user := range ui.AllUsers {
+			// Always search using original usernames, not censored ones
+			if text == "" || ContainsCaseInsensitive(user.Username, text) {
+				ui.FilteredUsers = append(ui.FilteredUsers, user)
+			}
+		}
+
+		ui.FilteredGroups = nil
+		for _, group := range ui.AllGroups {
+			if text == "" || ContainsCaseInsensitive(group.FullPath, text) {
+				ui.FilteredGroups = append(ui.FilteredGroups, group)
+			}
+		}
+
+		ui.FilteredProjects = nil
+		for _, project := range ui.AllProjects {
+			if text == "" || ContainsCaseInsensitive(project.PathWithNamespace, text) {
+				ui.FilteredProjects = append(ui.FilteredProjects, project)
+			}
+		}
+
+		UpdateUserList(userList, ui.FilteredUsers, ui.SelectedUsers)
+		UpdateGroupList(groupList, ui.FilteredGroups, ui.SelectedGroups)
+		UpdateProjectList(projectList, ui.FilteredProjects, ui.SelectedProjects)
+		showKeys(userList.GetCurrentItem())
+	}
+
+	// loadGroups fetches the groups the first time the Groups tab is shown
+	loadGroups := func() {
+		if ui.AllGroups != nil || cancelGroups != nil {
+			return
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		cancelGroups = cancel
+		groupList.Clear()
+		groupList.AddItem("Loading groups...", "Esc: Cancel", 0, nil)
+
+		go func() {
+			groups, err := ui.GitlabClient.FetchGroups(ctx)
+			ui.App.QueueUpdateDraw(func() {
+				// Cancelled with Esc
+				if ctx.Err() != nil {
+					return
+				}
+				cancel()
+				cancelGroups = nil
+				if err != nil {
+					groupList.Clear()
+					groupList.AddItem(fmt.Sprintf("[red]Error fetching groups: %v", tview.Escape(err.Error())),
+						tview.Escape(gitlab.Advice(err)), 0, nil)
+					return
+				}
+				ui.AllGroups = groups
+				applyFilter(searchInput.GetText())
+			})
+		}()
+	}
+
+	// loadProjects fetches the projects the first time the Projects tab is shown
+	loadProjects := func() {
+		if ui.AllProjects != nil || cancelProjects != nil {
+			return
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		cancelProjects = cancel
+		projectList.Clear()
+		projectList.AddItem("Loading projects...", "Esc: Cancel", 0, nil)
+
+		go func() {
+			projects, err := ui.GitlabClient.FetchProjects(ctx)
+			ui.App.QueueUpdateDraw(func() {
+				// Cancelled with Esc
+				if ctx.Err() != nil {
+					return
+				}
+				cancel()
+				cancelProjects = nil
+				if err != nil {
+					projectList.Clear()
+					projectList.AddItem(fmt.Sprintf("[red]Error fetching projects: %v", tview.Escape(err.Error())),
+						tview.Escape(gitlab.Advice(err)), 0, nil)
+					return
+				}
+				ui.AllProjects = projects
+				applyFilter(searchInput.GetText())
+			})
+		}()
+	}
+
+	// showKeys shows the keys of the user at index in the user list,
+	// fetching them in the background the first time
+	showKeys = func(index int) {
+		if index < 0 || index >= len(ui.FilteredUsers) {
+			keysUser = models.User{}
+			keyList.Clear()
+			keyList.SetTitle("Keys")
+			return
+		}
+		user := ui.FilteredUsers[index]
+		keysUser = user
+		keyList.SetTitle("Keys of " + DisplayUser(user))
+		if keys, ok := userKeys[user.Key()]; ok {
+			UpdateKeyList(keyList, user, keys, ui.ExcludedKeys)
+			return
+		}
+
+		keyList.Clear()
+		keyList.AddItem("Loading keys...", "Esc: Cancel", 0, nil)
+		if fetchingKeys[user.Key()] != nil {
+			return
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		fetchingKeys[user.Key()] = cancel
+
+		go func() {
+			keys, err := ui.Providers.FetchKeys(ctx, user)
+			ui.App.QueueUpdateDraw(func() {
+				// Cancelled with Esc
+				if ctx.Err() != nil {
+					return
+				}
+				cancel()
+				delete(fetchingKeys, user.Key())
+				// Errors are not kept, so the keys are fetched again the
+				// next time the user is highlighted
+				if err == nil {
+					userKeys[user.Key()] = keys
+				}
+				if keysUser != user {
+					return
+				}
+				if err != nil {
+					keyList.Clear()
+					keyList.AddItem(fmt.Sprintf("[red]Error fetching keys: %v", tview.Escape(err.Error())),
+						tview.Escape(gitlab.Advice(err)), 0, nil)
+					return
+				}
+				showKeys(userList.GetCurrentItem())
+				updateBottomBar()
+			})
+		}()
+	}
+
+	// cancelLoading cancels fetching what the active list is waiting for, and
+	// reports whether anything was being fetched. Switching to the tab again
+	// or highlighting the user again fetches it anew.
+	cancelLoading := func() bool {
+		switch activeTab {
+		case "Groups":
+			if cancelGroups == nil {
+				return false
+			}
+			cancelGroups()
+			cancelGroups = nil
+			groupList.Clear()
+			groupList.AddItem("[yellow]Loading groups cancelled", "Switch tabs with Ctrl-G to try again", 0, nil)
+		case "Projects":
+			if cancelProjects == nil {
+				return false
+			}
+			cancelProjects()
+			cancelProjects = nil
+			projectList.Clear()
+			projectList.AddItem("[yellow]Loading projects cancelled", "Switch tabs with Ctrl-G to try again", 0, nil)
+		default:
+			cancel := fetchingKeys[keysUser.Key()]
+			if cancel == nil {
+				return false
+			}
+			cancel()
+			delete(fetchingKeys, keysUser.Key())
+			keyList.Clear()
+			keyList.AddItem("[yellow]Loading keys cancelled", "Highlight the user again to try again", 0, nil)
+		}
+		return true
+	}
+
+	// switchTab cycles the Recipients panel through users, groups and projects
+	switchTab := func() {
+		switch activeTab {
+		case "Users":
+			activeTab = "Groups"
+			loadGroups()
+		case "Groups":
+			activeTab = "Projects"
+			loadProjects()
+		default:
+			activeTab = "Users"
+		}
+		recipientPages.SwitchToPage(activeTab)
+		usersPanel.SetTitle(panelTitle())
+		// Keys are only shown next to the users
+		if activeTab == "Users" {
+			usersPanel.ResizeItem(keyList, 0, 1)
+		} else {
+			usersPanel.ResizeItem(keyList, 0, 0)
+		}
+		ui.App.SetFocus(activeList())
+		updateBottomBar()
+	}
+
+	// selectProject asks for the minimum access level of the project members
+	// to encrypt to and marks the project as selected
+	selectProject := func(project models.Project, index int) {
+		levels := []models.AccessLevel{models.DeveloperAccess, models.MaintainerAccess, models.OwnerAccess}
+		var buttons []string
+		for _, level := range levels {
+			buttons = append(buttons, level.String())
+		}
+
+		modal := tview.NewModal().
+			SetText(fmt.Sprintf("Encrypt to the members of %s with at least which access level?", project.PathWithNamespace)).
+			AddButtons(append(buttons, "Cancel")).
+			SetDoneFunc(func(buttonIndex int, buttonLabel string) {
+				if buttonIndex >= 0 && buttonIndex < len(levels) {
+					ui.SelectedProjects[project.ID] = levels[buttonIndex]
+					UpdateProjectList(projectList, ui.FilteredProjects, ui.SelectedProjects)
+					projectList.SetCurrentItem(index)
+				}
+				ui.App.SetRoot(layout, true).SetFocus(projectList)
+				updateBottomBar()
+			})
+		ui.App.SetRoot(modal, false)
+	}
+
+	// backToLayout returns to the main layout after encrypting was cancelled
+	backToLayout := func() {
+		ui.App.SetRoot(layout, true).SetFocus(encryptButton)
+		updateBottomBar()
+	}
+
+	// encryptionFailed shows why encrypting failed
+	encryptionFailed := func(err error) {
+		showError("Encryption failed: " + tview.Escape(ErrorMessage(err)))
+	}
+
+	// encryptFile encrypts the chosen file to the given recipients and writes
+	// the result next to it
+	encryptFile := func(recipients []age.Recipient, armored bool) {
+		output := encryption.EncryptedPath(ui.SelectedFile)
+		title := fmt.Sprintf("Encrypting %s...", tview.Escape(ui.SelectedFile))
+		progressText := tview.NewTextView().
+			SetDynamicColors(true).
+			SetText(title).
+			SetTextAlign(tview.AlignCenter)
+		ui.App.SetRoot(progressText, true)
+
+		progress := func(done, total int64) {
+			ui.App.QueueUpdateDraw(func() {
+				progressText.SetText(title + "\n\n" + ProgressBar(done, total))
+			})
+		}
+
+		go func() {
+			err := encryption.EncryptFile(ui.SelectedFile, output, recipients, armored, progress)
+			if err != nil {
+				ui.App.QueueUpdateDraw(func() {
+					showError(fmt.Sprintf("Encryption failed: %v", err))
+				})
+				return
+			}
+			stopWithOutput(ui.App, fmt.Sprintf("Encrypted %s to %s", ui.SelectedFile, output))
+		}()
+	}
+
+	// rekeyFile decrypts the file being re-encrypted and encrypts it to the
+	// given recipients at the same time, writing the result to output
+	rekeyFile := func(recipients []age.Recipient, output string) {
+		title := "Re-encrypting..."
+		progressText := tview.NewTextView().
+			SetDynamicColors(true).
+			SetText(title).
+			SetTextAlign(tview.AlignCenter)
+		ui.App.SetRoot(progressText, true)
+
+		progress := func(done, total int64) {
+			ui.App.QueueUpdateDraw(func() {
+				progressText.SetText(title + "\n\n" + ProgressBar(done, total))
+			})
+		}
+
+		go func() {
+			err := encryption.WriteFile(output, func(w io.Writer) error {
+				return encryption.Reencrypt(w, strings.NewReader(ui.RekeyCiphertext), ui.RekeyIdentities, recipients, true, progress)
+			})
+			if err != nil {
+				ui.App.QueueUpdateDraw(func() {
+					encryptionFailed(err)
+				})
+				return
+			}
+			stopWithOutput(ui.App, "Re-encrypted to "+output)
+		}()
+	}
+
+	// promptForRekeyOutput asks where to write the re-encrypted file, which
+	// is ASCII-armored like the file from the clipboard
+	promptForRekeyOutput := func(selected []models.User) {
+		form := tview.NewForm()
+
+		output := "rekeyed.age"
+		form.AddInputField("Write to:", output, 50, nil, func(text string) {
+			output = text
+		})
+
+		verify := func() {
+			VerifyKeys(ui.App, selected, ui.Providers, ui.ExcludedKeys, func(recipients []age.Recipient) {
+				rekeyFile(recipients, output)
+			}, encryptionFailed, backToLayout)
+		}
+
+		form.AddButton("Re-encrypt", func() {
+			if output == "" {
+				return
+			}
+
+			// Ask before replacing an existing file
+			if _, err := os.Stat(output); err == nil {
+				confirm := tview.NewModal().
+					SetText(fmt.Sprintf("%s already exists. Replace it?", output)).
+					AddButtons([]string{"Replace", "Cancel"}).
+					SetDoneFunc(func(buttonIndex int, buttonLabel string) {
+						if buttonLabel != "Replace" {
+							ui.App.SetRoot(form, true).SetFocus(form)
+							return
+						}
+						verify()
+					})
+				ui.App.SetRoot(confirm, false)
+				return
+			}
+			verify()
+		})
+
+		form.AddButton("Cancel", backToLayout)
+
+		form.SetBorder(true).SetTitle("Re-encrypt").SetTitleAlign(tview.AlignCenter)
+		ui.App.SetRoot(form, true).SetFocus(form)
+	}
+
+	// encrypt encrypts the data to the given users and prints the result
+	encrypt := func(selected []models.User) {
+		if ui.RekeyCiphertext != "" {
+			promptForRekeyOutput(selected)
+			return
+		}
+		if ui.SelectedFile != "" {
+			output := encryption.EncryptedPath(ui.SelectedFile)
+			modal := tview.NewModal().
+				SetText(fmt.Sprintf("Encrypt %s to %s as binary or ASCII-armored file?",
+					ui.SelectedFile, output)).
+				AddButtons([]string{"Binary", "ASCII Armor", "Cancel"}).
+				SetDoneFunc(func(buttonIndex int, buttonLabel string) {
+					if buttonLabel != "Binary" && buttonLabel != "ASCII Armor" {
+						backToLayout()
+						return
+					}
+					VerifyKeys(ui.App, selected, ui.Providers, ui.ExcludedKeys, func(recipients []age.Recipient) {
+						encryptFile(recipients, buttonLabel == "ASCII Armor")
+					}, encryptionFailed, backToLayout)
+				})
+
+			// Ask before replacing an earlier encrypted file
+			if _, err := os.Stat(output); err == nil {
+				confirm := tview.NewModal().
+					SetText(fmt.Sprintf("%s already exists. Replace it?", output)).
+					AddButtons([]string{"Replace", "Cancel"}).
+					SetDoneFunc(func(buttonIndex int, buttonLabel string) {
+						if buttonLabel != "Replace" {
+							backToLayout()
+							return
+						}
+						ui.App.SetRoot(modal, false)
+					})
+				ui.App.SetRoot(confirm, false)
+				return
+			}
+			ui.App.SetRoot(modal, false)
+			return
+		}
+
+		VerifyKeys(ui.App, selected, ui.Providers, ui.ExcludedKeys, func(recipients []age.Recipient) {
+			encrypted, err := encryption.EncryptData(dataInput.GetText(), recipients)
+			if err != nil {
+				encryptionFailed(err)
+				return
+			}
+			stopWithOutput(ui.App, encrypted)
+		}, encryptionFailed, backToLayout)
+	}
+
+	// showFile shows the chosen file in the Data panel, or the text area
+	// again if path is empty
+	showFile := func(path string) {
+		ui.SelectedFile = path
+		if path == "" {
+			dataPages.SwitchToPage("Text")
+			return
+		}
+
+		description := "File"
+		if info, err := os.Stat(path); err == nil {
+			if info.IsDir() {
+				description = "Directory (encrypted as a compressed archive)"
+			} else {
+				description = fmt.Sprintf("File (%d bytes)", info.Size())
+			}
+		}
+		fileView.SetText(fmt.Sprintf("[yellow]%s:[white]\n%s\n\nEncrypted to:\n%s",
+			description, tview.Escape(path), tview.Escape(encryption.EncryptedPath(path))))
+		dataPages.SwitchToPage("File")
+	}
+
+	// chooseFile shows the file picker
+	chooseFile := func() {
+		picker := CreateFilePicker(".",
+			func(path string) {
+				showFile(path)
+				ui.App.SetRoot(layout, true).SetFocus(encryptButton)
+				updateBottomBar()
+			},
+			func() {
+				ui.App.SetRoot(layout, true).SetFocus(fileButton)
+				updateBottomBar()
+			})
+		ui.App.SetRoot(picker, true)
+	}
+
+	// confirmMembers expands the selected groups and projects into their
+	// members and asks for confirmation before encrypting to all of them
+	confirmMembers := func() {
+		ctx, cancel := context.WithCancel(context.Background())
+
+		loadingText := tview.NewTextView().
+			SetText("Resolving group and project members...\n\nEsc: Cancel").
+			SetTextAlign(tview.AlignCenter)
+		loadingText.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+			if event.Key() == tcell.KeyEscape {
+				cancel()
+				backToLayout()
+				return nil
+			}
+			return event
+		})
+		ui.App.SetRoot(loadingText, true)
+
+		go func() {
+			selected, summary, err := ui.ExpandSelection(ctx)
+			ui.App.QueueUpdateDraw(func() {
+				// Cancelled with Esc
+				if ctx.Err() != nil {
+					return
+				}
+				cancel()
+				if err != nil {
+					showError("Error fetching members: " + tview.Escape(ErrorMessage(err)))
+					return
+				}
+				confirm := CreateConfirmView("Confirm Recipients", summary, "Encrypt",
+					func() { encrypt(selected) },
+					func() {
+						ui.App.SetRoot(layout, true).SetFocus(encryptButton)
+						updateBottomBar()
+					})
+				ui.App.SetRoot(confirm, true)
+			})
+		}()
+	}
+
+	// recipientListCapture handles keys shared by the user and group lists
+	recipientListCapture := func(event *tcell.EventKey) *tcell.EventKey {
+		switch event.Key() {
+		case tcell.KeyTab:
+			if ui.HasRecipients() {
+				ui.App.SetFocus(dataView())
+				updateBottomBar()
+			}
+			return nil
+		case tcell.KeyCtrlG:
+			switchTab()
+			return nil
+		case tcell.KeyCtrlP:
+			switchProfile()
+			return nil
+		case tcell.KeyCtrlK:
+			if activeTab == "Users" && len(userKeys[keysUser.Key()]) > 0 {
+				ui.App.SetFocus(keyList)
+				updateBottomBar()
+			}
+			return nil
+		case tcell.KeyEscape:
+			if cancelLoading() {
+				return nil
+			}
+			ui.App.SetFocus(searchInput)
+			updateBottomBar()
+			return event
+		case tcell.KeyUp, tcell.KeyDown, tcell.KeyEnter:
+			return event
+		case tcell.KeyRune:
+			ui.App.SetFocus(searchInput)
+			current := searchInput.GetText()
+			searchInput.SetText(current + string(event.Rune()))
+			updateBottomBar()
+			return nil
+		default:
+			ui.App.SetFocus(searchInput)
+			updateBottomBar()
+			return event
+		}
+	}
+
+	// refreshUsers fetches the users again in the background, revalidating
+	// what was cached, and updates the list if they changed. It is cancelled
+	// when switching profiles.
+	refreshUsers := func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancelRefresh = cancel
+
+		go func() {
+			users, err := ui.Providers.ListUsers(ctx)
+			ui.App.QueueUpdateDraw(func() {
+				if ctx.Err() != nil {
+					return
+				}
+				cancel()
+				// The cached users are still usable
+				if err != nil || sameUsers(users, ui.AllUsers) {
+					return
+				}
+				ui.AllUsers = users
+				applyFilter(searchInput.GetText())
+				updateBottomBar()
+			})
+		}()
+	}
 
 	go func() {
-		users, err := ui.GitlabClient.FetchUsers()
+		// Show the cached users right away, however old, and refresh them
+		// once the list is shown
+		users, err := ui.Providers.ListUsers(cache.WithMaxAge(ctx, cache.Forever))
+
+		// Preselect the current recipients of a file being re-encrypted
+		var rekeyWarning string
+		if err == nil && ui.RekeyRecipients != nil {
+			ui.AllUsers = users
+			rekeyWarning = ui.preselectRekeyRecipients(ctx)
+		}
+		if err == nil {
+			err = ctx.Err()
+		}
+		cancel()
 		if err != nil {
+			message := "Error fetching users: " + tview.Escape(ErrorMessage(err))
+			if errors.Is(err, context.Canceled) {
+				message = "Loading the users was cancelled."
+			}
 			ui.App.QueueUpdateDraw(func() {
+				// A profile with wrong settings can be switched away from
 				modal := tview.NewModal().
-					SetText(fmt.Sprintf("Error fetching users: %v", err)).
-					AddButtons([]string{"Quit"}).
-					SetDoneFunc(func(buttonIndex int, buttonLabel string) { ui.App.Stop() })
+					SetText(message).
+					AddButtons([]string{"Switch Profile", "Quit"})
+				modal.SetDoneFunc(func(buttonIndex int, buttonLabel string) {
+					if buttonLabel != "Switch Profile" {
+						ui.App.Stop()
+						return
+					}
+					ui.App.SetRoot(CreateProfileSwitcher(ui.App, func(name string) {
+						ui.restart(ui.InitialData)
+					}, func() {
+						ui.App.SetRoot(modal, false)
+					}), true)
+				})
 				ui.App.SetRoot(modal, false)
 			})
 			return
@@ -170,132 +1022,229 @@ func (ui *EncryptionUI) LoadUsers() {
 		ui.AllUsers = users
 		ui.FilteredUsers = users
 
+		// Create the list of the highlighted user's keys, in which keys can
+		// be excluded from encryption.
+		keyList = tview.NewList()
+		keyList.SetBorder(true).SetTitle("Keys")
+		keyList.SetSelectedFunc(func(index int, mainText, secondaryText string, shortcut rune) {
+			keys := userKeys[keysUser.Key()]
+			if index < 0 || index >= len(keys) {
+				return
+			}
+			ui.ExcludedKeys.Toggle(keysUser, keys[index].Key)
+			UpdateKeyList(keyList, keysUser, keys, ui.ExcludedKeys)
+			keyList.SetCurrentItem(index)
+		})
+		keyList.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+			switch event.Key() {
+			case tcell.KeyTab, tcell.KeyEscape, tcell.KeyCtrlK:
+				ui.App.SetFocus(userList)
+				updateBottomBar()
+				return nil
+			}
+			return event
+		})
+
 		// Create user list.
-		userList := tview.NewList()
+		userList = tview.NewList()
 		UpdateUserList(userList, ui.FilteredUsers, ui.SelectedUsers)
+		userList.SetChangedFunc(func(index int, mainText, secondaryText string, shortcut rune) {
+			// The highlighted item is only updated after this is called
+			showKeys(index)
+		})
 		userList.SetSelectedFunc(func(index int, mainText, secondaryText string, shortcut rune) {
 			if index < 0 || index >= len(ui.FilteredUsers) {
 				return
 			}
 			u := ui.FilteredUsers[index]
-			if ui.SelectedUsers[u.ID] {
-				delete(ui.SelectedUsers, u.ID)
+			if ui.SelectedUsers[u.Key()] {
+				delete(ui.SelectedUsers, u.Key())
 			} else {
-				ui.SelectedUsers[u.ID] = true
+				ui.SelectedUsers[u.Key()] = true
 			}
 			UpdateUserList(userList, ui.FilteredUsers, ui.SelectedUsers)
 			userList.SetCurrentItem(index)
-			UpdateBottomBar(ui.App, bottomBar, searchInput, userList, dataInput, encryptButton)
+			updateBottomBar()
 		})
-		userList.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
-			if event.Key() == tcell.KeyTab {
-				if len(ui.SelectedUsers) > 0 {
-					ui.App.SetFocus(dataInput)
-					UpdateBottomBar(ui.App, bottomBar, searchInput, userList, dataInput, encryptButton)
-				}
-				return nil
+		userList.SetInputCapture(recipientListCapture)
+
+		// Create group list, filled when the groups tab is first shown.
+		groupList = tview.NewList()
+		groupList.SetSelectedFunc(func(index int, mainText, secondaryText string, shortcut rune) {
+			if index < 0 || index >= len(ui.FilteredGroups) {
+				return
 			}
-			switch event.Key() {
-			case tcell.KeyUp, tcell.KeyDown, tcell.KeyEnter:
-				return event
-			case tcell.KeyRune:
-				ui.App.SetFocus(searchInput)
-				current := searchInput.GetText()
-				searchInput.SetText(current + string(event.Rune()))
-				UpdateBottomBar(ui.App, bottomBar, searchInput, userList, dataInput, encryptButton)
-				return nil
-			default:
-				ui.App.SetFocus(searchInput)
-				UpdateBottomBar(ui.App, bottomBar, searchInput, userList, dataInput, encryptButton)
-				return event
+			g := ui.FilteredGroups[index]
+			if ui.SelectedGroups[g.ID] {
+				delete(ui.SelectedGroups, g.ID)
+			} else {
+				ui.SelectedGroups[g.ID] = true
+			}
+			UpdateGroupList(groupList, ui.FilteredGroups, ui.SelectedGroups)
+			groupList.SetCurrentItem(index)
+			updateBottomBar()
+		})
+		groupList.SetInputCapture(recipientListCapture)
+
+		// Create project list, filled when the projects tab is first shown.
+		projectList = tview.NewList()
+		projectList.SetSelectedFunc(func(index int, mainText, secondaryText string, shortcut rune) {
+			if index < 0 || index >= len(ui.FilteredProjects) {
+				return
+			}
+			p := ui.FilteredProjects[index]
+			if _, ok := ui.SelectedProjects[p.ID]; ok {
+				delete(ui.SelectedProjects, p.ID)
+				UpdateProjectList(projectList, ui.FilteredProjects, ui.SelectedProjects)
+				projectList.SetCurrentItem(index)
+				updateBottomBar()
+				return
 			}
+			selectProject(p, index)
 		})
+		projectList.SetInputCapture(recipientListCapture)
+
+		activeTab = "Users"
+		recipientPages = tview.NewPages().
+			AddPage("Users", userList, true, true).
+			AddPage("Groups", groupList, true, false).
+			AddPage("Projects", projectList, true, false)
 
 		// Create search input.
 		searchInput = tview.NewInputField()
 		searchInput.SetChangedFunc(func(text string) {
-			ui.FilteredUsers = nil
-			searchText := strings.ToLower(text)
-			
-			for _, user := range ui.AllUsers {
-				// Always search using original usernames, not censored ones
-				if text == "" || ContainsCaseInsensitive(user.Username, searchText) {
-					ui.FilteredUsers = append(ui.FilteredUsers, user)
-				}
-			}
-			
-			UpdateUserList(userList, ui.FilteredUsers, ui.SelectedUsers)
-			UpdateBottomBar(ui.App, bottomBar, searchInput, userList, dataInput, encryptButton)
+			applyFilter(text)
+			updateBottomBar()
 		})
 		searchInput.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
 			switch event.Key() {
 			case tcell.KeyUp, tcell.KeyDown, tcell.KeyEnter:
-				ui.App.SetFocus(userList)
-				UpdateBottomBar(ui.App, bottomBar, searchInput, userList, dataInput, encryptButton)
+				ui.App.SetFocus(activeList())
+				updateBottomBar()
+				return nil
+			case tcell.KeyCtrlG:
+				switchTab()
+				return nil
+			case tcell.KeyCtrlP:
+				switchProfile()
 				return nil
 			}
 			return event
 		})
 
-		// Left panel: search input and user list.
-		usersPanel := tview.NewFlex().SetDirection(tview.FlexRow).
+		// Left panel: search input, user, group or project list, and the
+		// keys of the highlighted user.
+		usersPanel = tview.NewFlex().SetDirection(tview.FlexRow).
 			AddItem(searchInput, 3, 0, true).
-			AddItem(userList, 0, 1, false)
-		usersPanel.SetBorder(true).SetTitle("Recipients")
+			AddItem(recipientPages, 0, 2, false).
+			AddItem(keyList, 0, 1, false)
+		usersPanel.SetBorder(true).SetTitle(panelTitle())
+		if gitlab.InsecureSkipVerify() {
+			// Make it hard to miss that anyone could swap the keys
+			usersPanel.SetTitleColor(tcell.ColorRed)
+		}
 
 		// Create Data panel as a text area.
 		dataInput = tview.NewTextArea().
 			SetWrap(true).
-			SetWordWrap(true)
+			SetWordWrap(true).
+			SetText(ui.InitialData, false)
 			
 		// Add encrypt button
 		encryptButton = tview.NewButton("Encrypt").
 			SetSelectedFunc(func() {
-				if dataInput.GetText() != "" {
-					go func() {
-						encrypted, err := encryption.EncryptData(dataInput.GetText(), ui.SelectedUsers, ui.GitlabClient)
-						if err != nil {
-							ui.App.QueueUpdateDraw(func() {
-								modal := tview.NewModal().
-									SetText(fmt.Sprintf("Encryption failed: %v", err)).
-									AddButtons([]string{"OK"}).
-									SetDoneFunc(func(buttonIndex int, buttonLabel string) {
-										ui.App.SetRoot(layout, true).SetFocus(dataInput)
-										UpdateBottomBar(ui.App, bottomBar, searchInput, userList, dataInput, encryptButton)
-									})
-								ui.App.SetRoot(modal, false)
-							})
-							return
-						}
-						ui.App.Stop()
-						fmt.Println(encrypted)
-					}()
+				if dataInput.GetText() == "" && ui.SelectedFile == "" && ui.RekeyCiphertext == "" {
+					return
+				}
+				if len(ui.SelectedGroups) > 0 || len(ui.SelectedProjects) > 0 {
+					confirmMembers()
+					return
 				}
+				encrypt(ui.SelectedUserList())
 			})
 
 		dataInput.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
 			if event.Key() == tcell.KeyTab {
 				ui.App.SetFocus(encryptButton)
-				UpdateBottomBar(ui.App, bottomBar, searchInput, userList, dataInput, encryptButton)
+				updateBottomBar()
 				return nil
 			}
-			UpdateBottomBar(ui.App, bottomBar, searchInput, userList, dataInput, encryptButton)
+			updateBottomBar()
 			return event
 		})
 
 		encryptButton.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
 			if event.Key() == tcell.KeyTab {
-				ui.App.SetFocus(userList)
-				UpdateBottomBar(ui.App, bottomBar, searchInput, userList, dataInput, encryptButton)
+				// Only the file being re-encrypted can be encrypted
+				if ui.RekeyCiphertext != "" {
+					ui.App.SetFocus(activeList())
+					updateBottomBar()
+					return nil
+				}
+				ui.App.SetFocus(fileButton)
+				updateBottomBar()
 				return nil
 			}
 			return event
 		})
 
+		// Add button to encrypt a file or directory instead of text
+		fileButton = tview.NewButton("Choose File").
+			SetSelectedFunc(chooseFile)
+		fileButton.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+			if event.Key() == tcell.KeyTab {
+				ui.App.SetFocus(activeList())
+				updateBottomBar()
+				return nil
+			}
+			return event
+		})
+
+		// Details of the chosen file, shown instead of the text area
+		fileView = tview.NewTextView().
+			SetDynamicColors(true).
+			SetWrap(true)
+		fileView.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+			switch event.Key() {
+			case tcell.KeyTab:
+				ui.App.SetFocus(encryptButton)
+				updateBottomBar()
+				return nil
+			case tcell.KeyBackspace, tcell.KeyBackspace2, tcell.KeyDelete:
+				if ui.RekeyCiphertext != "" {
+					return nil
+				}
+				showFile("")
+				ui.App.SetFocus(dataInput)
+				updateBottomBar()
+				return nil
+			}
+			return event
+		})
+
+		dataPages = tview.NewPages().
+			AddPage("Text", dataInput, true, true).
+			AddPage("File", fileView, true, false)
+
+		buttons := tview.NewFlex().
+			AddItem(encryptButton, 0, 1, false)
+		if ui.RekeyCiphertext != "" {
+			fileView.SetText(fmt.Sprintf("[yellow]Age file from the clipboard[white] (%d bytes)\n\n"+
+				"Its content is decrypted again while re-encrypting, without being shown.",
+				len(ui.RekeyCiphertext)))
+			dataPages.SwitchToPage("File")
+		} else {
+			buttons.AddItem(nil, 1, 0, false).
+				AddItem(fileButton, 0, 1, false)
+		}
+
 		dataPanel := tview.NewFlex().SetDirection(tview.FlexRow).
-			AddItem(dataInput, 0, 1, false).
-			AddItem(encryptButton, 1, 0, false)
+			AddItem(dataPages, 0, 1, false).
+			AddItem(buttons, 1, 0, false)
 		dataPanel.SetBorder(true).SetTitle("Data")
+		if ui.RekeyRecipients != nil {
+			dataPanel.SetTitle("Data - Re-encrypting")
+		}
 
 		// Create Bottom bar.
 		bottomBar = tview.NewTextView().
@@ -314,7 +1263,137 @@ func (ui *EncryptionUI) LoadUsers() {
 		ui.App.QueueUpdateDraw(func() {
 			bottomBar.SetText("↑/↓: move highlight | Enter: toggle selection")
 			ui.App.SetRoot(layout, true).SetFocus(userList)
-			UpdateBottomBar(ui.App, bottomBar, searchInput, userList, dataInput, encryptButton)
+			showKeys(userList.GetCurrentItem())
+			updateBottomBar()
+			if rekeyWarning != "" {
+				showError(rekeyWarning)
+			}
+			if !cache.Offline() {
+				refreshUsers()
+			}
 		})
 	}()
-} 
+}
+
+// sameUsers reports whether two user lists are the same
+func sameUsers(a, b []models.User) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// restart starts the encryption UI over, for instance after switching
+// profiles, keeping the data to encrypt
+func (ui *EncryptionUI) restart(data string) {
+	next := NewEncryptionUI(ui.App)
+	next.InitialData = data
+	next.SelectedFile = ui.SelectedFile
+	next.RekeyRecipients = ui.RekeyRecipients
+	next.RekeyCiphertext = ui.RekeyCiphertext
+	next.RekeyIdentities = ui.RekeyIdentities
+	next.StartEncryptionUI()
+}
+
+// preselectRekeyRecipients selects the users the file being re-encrypted was
+// encrypted to. It returns a warning naming the recipients that lose access
+// because they cannot be matched to a user.
+func (ui *EncryptionUI) preselectRekeyRecipients(ctx context.Context) string {
+	if err := encryption.IdentifyRecipients(ctx, ui.RekeyRecipients, ui.AllUsers, ui.Providers); err != nil {
+		return "Could not match the current recipients to users: " + ErrorMessage(err)
+	}
+
+	var unknown []string
+	for _, recipient := range ui.RekeyRecipients {
+		if !recipient.Known() {
+			unknown = append(unknown, encryption.DescribeRecipient(recipient))
+			continue
+		}
+		ui.SelectedUsers[models.User{Username: recipient.Username, Source: recipient.Source}.Key()] = true
+	}
+
+	if len(unknown) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("These current recipients are not known users and will lose access:\n%s",
+		strings.Join(unknown, "\n"))
+}
+
+// HasRecipients reports whether any user, group or project has been selected
+func (ui *EncryptionUI) HasRecipients() bool {
+	return len(ui.SelectedUsers) > 0 || len(ui.SelectedGroups) > 0 || len(ui.SelectedProjects) > 0
+}
+
+// SelectedUserList returns the selected users in the order they are listed
+func (ui *EncryptionUI) SelectedUserList() []models.User {
+	var users []models.User
+	for _, user := range ui.AllUsers {
+		if ui.SelectedUsers[user.Key()] {
+			users = append(users, user)
+		}
+	}
+	return users
+}
+
+// ExpandSelection resolves the selected groups and projects into their members.
+// It returns the selected users together with all those members, and a summary
+// listing every recipient for confirmation.
+func (ui *EncryptionUI) ExpandSelection(ctx context.Context) ([]models.User, string, error) {
+	var selected []models.User
+	seen := make(map[string]bool)
+	var summary strings.Builder
+
+	add := func(users []models.User) {
+		for _, user := range users {
+			if !seen[user.Key()] {
+				seen[user.Key()] = true
+				selected = append(selected, user)
+			}
+		}
+	}
+	add(ui.SelectedUserList())
+
+	for _, group := range ui.AllGroups {
+		if !ui.SelectedGroups[group.ID] {
+			continue
+		}
+		members, err := ui.GitlabClient.FetchGroupMembers(ctx, strconv.Itoa(group.ID))
+		if err != nil {
+			return nil, "", fmt.Errorf("%s: %w", group.FullPath, err)
+		}
+		fmt.Fprintf(&summary, "Group [yellow]%s[white]: %d members\n", tview.Escape(group.FullPath), len(members))
+		add(members)
+	}
+
+	for _, project := range ui.AllProjects {
+		minAccess, ok := ui.SelectedProjects[project.ID]
+		if !ok {
+			continue
+		}
+		members, err := ui.GitlabClient.FetchProjectMembers(ctx, strconv.Itoa(project.ID), minAccess)
+		if err != nil {
+			return nil, "", fmt.Errorf("%s: %w", project.PathWithNamespace, err)
+		}
+		fmt.Fprintf(&summary, "Project [yellow]%s[white] (%s or higher): %d members\n",
+			tview.Escape(project.PathWithNamespace), minAccess, len(members))
+		add(members)
+	}
+
+	var names []string
+	for _, user := range selected {
+		names = append(names, DisplayUser(user))
+	}
+	sort.Strings(names)
+
+	fmt.Fprintf(&summary, "\nThe data will be encrypted to these %d users:\n\n", len(names))
+	for _, name := range names {
+		fmt.Fprintf(&summary, "  %s\n", tview.Escape(name))
+	}
+
+	return selected, summary.String(), nil
+}
@@ -0,0 +1,110 @@
+package ui
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+)
+
+// CreateFilePicker creates a tree of the files below dir to choose a file or
+// directory from. Directories are expanded with Enter and chosen with Space.
+func CreateFilePicker(dir string, onSelect func(path string), onCancel func()) tview.Primitive {
+	tree := tview.NewTreeView()
+	view := tview.NewFlex().SetDirection(tview.FlexRow)
+
+	// setRoot shows the contents of dir, with an entry to go up a level
+	var setRoot func(dir string)
+	setRoot = func(dir string) {
+		root := tview.NewTreeNode(dir).SetColor(tcell.ColorYellow).SetReference(dir)
+		parent := filepath.Dir(dir)
+		if parent != dir {
+			up := tview.NewTreeNode("..").SetColor(tcell.ColorYellow).SetSelectedFunc(func() {
+				setRoot(parent)
+			})
+			root.AddChild(up)
+		}
+		addFileNodes(root, dir)
+		tree.SetRoot(root).SetCurrentNode(root)
+		view.SetTitle("Choose File - " + dir)
+	}
+
+	tree.SetSelectedFunc(func(node *tview.TreeNode) {
+		path, ok := node.GetReference().(string)
+		if !ok || node == tree.GetRoot() {
+			return
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return
+		}
+		if !info.IsDir() {
+			onSelect(path)
+			return
+		}
+
+		// Load directories the first time they are expanded
+		if len(node.GetChildren()) == 0 {
+			addFileNodes(node, path)
+			node.SetExpanded(true)
+			return
+		}
+		node.SetExpanded(!node.IsExpanded())
+	})
+
+	tree.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		switch {
+		case event.Key() == tcell.KeyEscape:
+			onCancel()
+			return nil
+		case event.Key() == tcell.KeyRune && event.Rune() == ' ':
+			if path, ok := tree.GetCurrentNode().GetReference().(string); ok {
+				onSelect(path)
+			}
+			return nil
+		}
+		return event
+	})
+
+	hint := tview.NewTextView().
+		SetTextAlign(tview.AlignCenter).
+		SetText("↑/↓: Move | ⏎ : Open Directory / Choose File | Space: Choose Directory | Esc: Cancel")
+
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		absDir = dir
+	}
+	view.AddItem(tree, 0, 1, true).
+		AddItem(hint, 1, 0, false)
+	view.SetBorder(true)
+	setRoot(absDir)
+
+	return view
+}
+
+// addFileNodes adds the entries of dir to node, directories first
+func addFileNodes(node *tview.TreeNode, dir string) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		node.AddChild(tview.NewTreeNode("[" + err.Error() + "]").SetColor(tcell.ColorRed).SetSelectable(false))
+		return
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].IsDir() && !entries[j].IsDir()
+	})
+
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		child := tview.NewTreeNode(entry.Name()).SetReference(path)
+		if entry.IsDir() {
+			child.SetText(entry.Name() + "/").SetColor(tcell.ColorGreen)
+		} else if !entry.Type().IsRegular() {
+			// Only regular files and directories can be encrypted
+			continue
+		}
+		node.AddChild(child)
+	}
+}
@@ -0,0 +1,87 @@
+package ui
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/deathrjj/age-gitlab-tool-tui/encryption"
+	"github.com/deathrjj/age-gitlab-tool-tui/models"
+	"github.com/rivo/tview"
+)
+
+// UpdateKeyList refreshes the list with the keys of a user, showing the type,
+// size, title, dates and usage of each key, and its fingerprint below.
+// Excluded keys are prefixed with "✗ ", the others with "✓ ", and keys that
+// cannot be encrypted to are marked with the reason.
+func UpdateKeyList(list *tview.List, user models.User, keys []models.Key, excluded models.KeyExclusionMap) {
+	list.Clear()
+
+	for _, key := range keys {
+		prefix := "✓ "
+		color := "green"
+		if excluded.Excluded(user, key.Key) {
+			prefix = "✗ "
+			color = "gray"
+		}
+
+		details, err := encryption.DescribeKey(key.Key)
+		text := fmt.Sprintf("[%s]%s", color, prefix+tview.Escape(DescribeKey(key, details, err)))
+		info := tview.Escape(KeyDates(key))
+		// Keys that cannot be encrypted to are skipped whether excluded or not
+		if reason := encryption.SkipReason(key); reason != "" && info != "" {
+			info = "[red]" + reason + "[gray], " + info
+		} else if reason != "" {
+			info = "[red]" + reason + "[gray]"
+		}
+		if info != "" {
+			text += " [gray](" + info + ")"
+		}
+		list.AddItem(text, "  [gray]"+tview.Escape(details.Fingerprint), 0, nil)
+	}
+	if len(keys) == 0 {
+		list.AddItem("[red]No keys", "", 0, nil)
+	}
+}
+
+// DescribeKey returns the type, size and title of a key, such as
+// "ed25519 256 laptop", given the details encryption.DescribeKey returned
+func DescribeKey(key models.Key, details encryption.KeyDetails, err error) string {
+	var parts []string
+	switch {
+	case err == nil:
+		parts = append(parts, details.Type)
+		if details.Bits > 0 {
+			parts = append(parts, fmt.Sprint(details.Bits))
+		}
+	case len(strings.Fields(key.Key)) > 0:
+		parts = append(parts, "unsupported "+strings.Fields(key.Key)[0]+" key")
+	default:
+		parts = append(parts, "unsupported key")
+	}
+	if key.Title != "" {
+		parts = append(parts, key.Title)
+	}
+	return strings.Join(parts, " ")
+}
+
+// KeyDates returns when a key was created and expires and what it may be
+// used for, as far as its source tells
+func KeyDates(key models.Key) string {
+	var parts []string
+	if key.CreatedAt != nil {
+		parts = append(parts, "created "+key.CreatedAt.Format(time.DateOnly))
+	}
+	switch {
+	case key.ExpiresAt != nil && key.ExpiresAt.Before(time.Now()):
+		parts = append(parts, "expired "+key.ExpiresAt.Format(time.DateOnly))
+	case key.ExpiresAt != nil:
+		parts = append(parts, "expires "+key.ExpiresAt.Format(time.DateOnly))
+	case key.CreatedAt != nil:
+		parts = append(parts, "never expires")
+	}
+	if key.UsageType != "" {
+		parts = append(parts, key.UsageType)
+	}
+	return strings.Join(parts, ", ")
+}
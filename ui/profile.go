@@ -0,0 +1,95 @@
+package ui
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/deathrjj/age-gitlab-tool-tui/config"
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+)
+
+// OfferToSaveProfile asks whether to save the settings entered at the prompts,
+// described by what, for the active profile. save stores the settings for the
+// named profile. next is called afterwards either way.
+func OfferToSaveProfile(app *tview.Application, what string, save func(profile string) error, next func()) {
+	name := config.Active()
+	modal := tview.NewModal().
+		SetText(fmt.Sprintf("Save the %s for the profile %q, so you are not asked again?", what, name)).
+		AddButtons([]string{"Save", "Not Now"}).
+		SetDoneFunc(func(buttonIndex int, buttonLabel string) {
+			if buttonLabel != "Save" {
+				next()
+				return
+			}
+			if err := save(name); err != nil {
+				errorModal := tview.NewModal().
+					SetText(fmt.Sprintf("Could not save the profile: %v", err)).
+					AddButtons([]string{"OK"}).
+					SetDoneFunc(func(buttonIndex int, buttonLabel string) { next() })
+				app.SetRoot(errorModal, false)
+				return
+			}
+			os.Setenv(config.ProfileEnv, name)
+			next()
+		})
+	app.SetRoot(modal, false)
+}
+
+// CreateProfileSwitcher creates a list of the profiles of the configuration
+// file. onSelect is called with the chosen profile once it has been applied,
+// and onCancel if the list is closed with Esc or there is nothing to choose.
+func CreateProfileSwitcher(app *tview.Application, onSelect func(name string), onCancel func()) tview.Primitive {
+	showMessage := func(message string) tview.Primitive {
+		return tview.NewModal().
+			SetText(message).
+			AddButtons([]string{"OK"}).
+			SetDoneFunc(func(buttonIndex int, buttonLabel string) { onCancel() })
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		return showMessage(fmt.Sprintf("Could not load the profiles: %v", err))
+	}
+	if len(cfg.Profiles) == 0 {
+		path, _ := config.Path()
+		return showMessage(fmt.Sprintf("No profiles are configured in %s yet.", path))
+	}
+
+	active := os.Getenv(config.ProfileEnv)
+	list := tview.NewList().ShowSecondaryText(true)
+	for _, name := range cfg.Names() {
+		name := name
+		label := "- " + name
+		if name == active {
+			label = "[green]✓ " + name
+		}
+		list.AddItem(label, "  "+tview.Escape(cfg.Profiles[name].GitLabURL), 0, func() {
+			if err := cfg.Apply(name, true); err != nil {
+				app.SetRoot(showMessage(err.Error()), false)
+				return
+			}
+			onSelect(name)
+		})
+		if name == active {
+			list.SetCurrentItem(list.GetItemCount() - 1)
+		}
+	}
+	list.SetDoneFunc(onCancel)
+	list.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		if event.Key() == tcell.KeyCtrlP {
+			onCancel()
+			return nil
+		}
+		return event
+	})
+	list.SetBorder(true).SetTitle("Profiles")
+
+	bottomBar := tview.NewTextView().
+		SetTextAlign(tview.AlignCenter).
+		SetText("↑/↓: Move Highlight | ⏎ : Switch Profile | Esc: Cancel")
+
+	return tview.NewFlex().SetDirection(tview.FlexRow).
+		AddItem(list, 0, 1, true).
+		AddItem(bottomBar, 1, 0, false)
+}
@@ -0,0 +1,27 @@
+package ui
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/deathrjj/age-gitlab-tool-tui/encryption"
+)
+
+// progressBarWidth is the number of cells of a progress bar
+const progressBarWidth = 40
+
+// ProgressBar renders the progress of done out of total bytes as a bar with
+// the percentage. If total is unknown (negative), only done is shown.
+func ProgressBar(done, total int64) string {
+	if total <= 0 {
+		return encryption.FormatSize(done) + " processed"
+	}
+	if done > total {
+		done = total
+	}
+
+	filled := int(done * progressBarWidth / total)
+	return fmt.Sprintf("[green]%s[white]%s %3d%%\n%s / %s",
+		strings.Repeat("█", filled), strings.Repeat("░", progressBarWidth-filled),
+		done*100/total, encryption.FormatSize(done), encryption.FormatSize(total))
+}
@@ -0,0 +1,166 @@
+package ui
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"filippo.io/age"
+	"github.com/deathrjj/age-gitlab-tool-tui/config"
+	"github.com/deathrjj/age-gitlab-tool-tui/encryption"
+	"github.com/deathrjj/age-gitlab-tool-tui/models"
+	"github.com/deathrjj/age-gitlab-tool-tui/policy"
+	"github.com/deathrjj/age-gitlab-tool-tui/provider"
+	"github.com/deathrjj/age-gitlab-tool-tui/trust"
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+)
+
+// VerifyKeys fetches the keys of the users in the background and compares
+// them with the ones seen before. Changes are shown in a modal, and new keys
+// have to be trusted before next is called with the recipients, which leave
+// out the excluded keys. Keys that the key policy forbids or that cannot be
+// encrypted to are listed in another modal, to continue without them or not.
+// onCancel is called if encrypting is not continued, also when fetching the
+// keys is cancelled with Esc, and onError if the keys cannot be fetched or a
+// user has too few keys the policy allows.
+func VerifyKeys(app *tview.Application, users []models.User, providers provider.Set, excluded models.KeyExclusionMap,
+	next func(recipients []age.Recipient), onError func(err error), onCancel func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	loadingText := tview.NewTextView().
+		SetText(fmt.Sprintf("Fetching the keys of %d users...\n\nEsc: Cancel", len(users))).
+		SetTextAlign(tview.AlignCenter)
+	loadingText.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		if event.Key() == tcell.KeyEscape {
+			cancel()
+			onCancel()
+			return nil
+		}
+		return event
+	})
+	app.SetRoot(loadingText, true)
+
+	go func() {
+		var store *trust.Store
+		var changes []trust.Change
+		var recipients []age.Recipient
+		var usage []encryption.KeyUsage
+		var reports []policy.Report
+		var userKeys []encryption.UserKeys
+		keyPolicy, err := policy.FromEnvironment()
+		if err == nil {
+			userKeys, err = encryption.FetchKeys(ctx, users, providers)
+		}
+		if err == nil {
+			store, err = trust.Load(config.Active())
+		}
+		if err == nil {
+			changes, err = store.Verify(userKeys)
+		}
+		if err == nil {
+			// Changes are tracked for all keys, excluded or not
+			userKeys, err = encryption.ExcludeKeys(userKeys, excluded)
+		}
+		if err == nil {
+			userKeys, reports = keyPolicy.Apply(userKeys)
+			err = policy.Enforce(reports)
+		}
+		if err == nil {
+			recipients, usage = encryption.ParseUserKeys(userKeys)
+		}
+
+		app.QueueUpdateDraw(func() {
+			// Cancelled with Esc, and onCancel was called already
+			if ctx.Err() != nil {
+				return
+			}
+			cancel()
+			if err != nil {
+				onError(err)
+				return
+			}
+
+			// confirmSkipped lists the keys that are skipped, if any, before
+			// encrypting
+			confirmSkipped := func() {
+				skipped := encryption.SkippedKeys(usage)
+				if len(skipped) == 0 && len(reports) == 0 {
+					next(recipients)
+					return
+				}
+
+				var text strings.Builder
+				if len(reports) > 0 {
+					text.WriteString("The key policy forbids some keys, which are not encrypted to:\n\n")
+					for _, report := range reports {
+						fmt.Fprintf(&text, "- %s\n", tview.Escape(report.String()))
+					}
+				}
+				if len(reports) > 0 && len(skipped) > 0 {
+					text.WriteString("\n")
+				}
+				if len(skipped) > 0 {
+					text.WriteString("Some keys cannot be encrypted to, as they are expired, only meant for signing or not supported by age:\n\n")
+				}
+				unusable := false
+				for _, u := range skipped {
+					fmt.Fprintf(&text, "- %s\n", tview.Escape(u.String()))
+					unusable = unusable || !u.Usable()
+				}
+				if unusable {
+					text.WriteString("\nUsers without usable keys will not be able to decrypt.")
+				}
+
+				modal := tview.NewModal().
+					SetText(text.String()).
+					AddButtons([]string{"Continue", "Cancel"}).
+					SetDoneFunc(func(buttonIndex int, buttonLabel string) {
+						if buttonLabel != "Continue" {
+							onCancel()
+							return
+						}
+						next(recipients)
+					})
+				app.SetRoot(modal, false)
+			}
+
+			if len(changes) == 0 {
+				confirmSkipped()
+				return
+			}
+
+			var pending []trust.Change
+			var text strings.Builder
+			text.WriteString("The keys of some recipients changed since they were last seen:\n\n")
+			for _, change := range changes {
+				fmt.Fprintf(&text, "- %s\n", tview.Escape(change.String()))
+				if change.NeedsAcceptance() {
+					pending = append(pending, change)
+				}
+			}
+
+			buttons := []string{"Continue", "Cancel"}
+			if len(pending) > 0 {
+				text.WriteString("\nMake sure the new keys are genuine. Encrypt to them and trust them from now on?")
+				buttons = []string{"Trust and Encrypt", "Cancel"}
+			}
+
+			modal := tview.NewModal().
+				SetText(text.String()).
+				AddButtons(buttons).
+				SetDoneFunc(func(buttonIndex int, buttonLabel string) {
+					if buttonLabel == "Cancel" || buttonIndex < 0 {
+						onCancel()
+						return
+					}
+					if err := store.Accept(pending); err != nil {
+						onError(err)
+						return
+					}
+					confirmSkipped()
+				})
+			app.SetRoot(modal, false)
+		})
+	}()
+}